@@ -0,0 +1,422 @@
+// Package decimate simplifies triangle meshes with the quadric error metric,
+// see M. Garland, P. Heckbert, "Surface Simplification Using Quadric Error Metrics".
+//
+// Every vertex has a quadric, which is the sum of the squared distances to the planes
+// of its triangles. The edges are collapsed one by one, the cheapest first: the two vertices
+// of the edge are replaced with the point, which minimizes the sum of their quadrics.
+// A collapse is rejected if it would make the mesh non-manifold or flip a triangle.
+// The boundary vertices never move, so the boundary of the mesh is preserved.
+package decimate
+
+import (
+	"container/heap"
+	"math"
+
+	"github.com/krasin/voxel/surface"
+)
+
+// Options control the decimation. At least one of them must be set.
+type Options struct {
+	// Target is the number of triangles to stop at. Zero means no limit.
+	Target int
+	// MaxError limits the error of a collapse, which is the square root of its quadric cost:
+	// the sum of the squared distances from the new vertex to the planes of the original
+	// triangles around it. So, the new vertex is within MaxError from each of these planes,
+	// but the bound is stricter where many planes meet. Zero means no limit.
+	MaxError float64
+}
+
+// Decimate returns the simplified copy of the mesh. The edges are collapsed until
+// the mesh has no more than opt.Target triangles, or the error of the cheapest collapse
+// exceeds opt.MaxError, or there are no valid collapses left.
+// The vertex normals of the result are the area weighted normals of the triangles.
+func Decimate(m *surface.Mesh, opt Options) *surface.Mesh {
+	d := newDecimator(m)
+	if opt.Target > 0 || opt.MaxError > 0 {
+		d.run(opt)
+	}
+	return d.mesh()
+}
+
+// quadric is the symmetric 4x4 matrix of the quadric error.
+// It contains a², ab, ac, ad, b², bc, bd, c², cd, d² for the plane ax + by + cz + d = 0.
+type quadric [10]float64
+
+func planeQuadric(n surface.Vector, d float64) (q quadric) {
+	return quadric{
+		n.X * n.X, n.X * n.Y, n.X * n.Z, n.X * d,
+		n.Y * n.Y, n.Y * n.Z, n.Y * d,
+		n.Z * n.Z, n.Z * d,
+		d * d,
+	}
+}
+
+func (q *quadric) add(q2 *quadric) {
+	for i := range q {
+		q[i] += q2[i]
+	}
+}
+
+// eval returns the sum of the squared distances from p to the planes of the quadric.
+func (q *quadric) eval(p surface.Vector) float64 {
+	x, y, z := p.X, p.Y, p.Z
+	return q[0]*x*x + 2*q[1]*x*y + 2*q[2]*x*z + 2*q[3]*x +
+		q[4]*y*y + 2*q[5]*y*z + 2*q[6]*y +
+		q[7]*z*z + 2*q[8]*z +
+		q[9]
+}
+
+// minimum returns the point, where the quadric is minimal, if it's unique.
+func (q *quadric) minimum() (p surface.Vector, ok bool) {
+	a := [3][3]float64{
+		{q[0], q[1], q[2]},
+		{q[1], q[4], q[5]},
+		{q[2], q[5], q[7]},
+	}
+	b := [3]float64{-q[3], -q[6], -q[8]}
+	det := det3(a)
+	// The quadric of a flat or a cylindrical part of the surface is degenerate.
+	if tr := (a[0][0] + a[1][1] + a[2][2]) / 3; math.Abs(det) <= 1e-9*tr*tr*tr {
+		return p, false
+	}
+	var res [3]float64
+	for i := range res {
+		ai := a
+		for j := range ai {
+			ai[j][i] = b[j]
+		}
+		res[i] = det3(ai) / det
+	}
+	return surface.Vector{res[0], res[1], res[2]}, true
+}
+
+func det3(a [3][3]float64) float64 {
+	return a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+}
+
+// collapse is a candidate edge collapse: the vertex u is merged into v, which is moved to p.
+type collapse struct {
+	cost float64
+	u, v int
+	p    surface.Vector
+	// stamp is the sum of the versions of u and v at the time of the evaluation.
+	// If it's changed, the collapse is out of date.
+	stamp int
+}
+
+// less orders the collapses by the cost. The ties, which are common on the flat regions,
+// are broken by the edge, so the result doesn't depend on the order of the pushes.
+func (c *collapse) less(c2 *collapse) bool {
+	if c.cost != c2.cost {
+		return c.cost < c2.cost
+	}
+	if c.u != c2.u {
+		return c.u < c2.u
+	}
+	if c.v != c2.v {
+		return c.v < c2.v
+	}
+	return c.stamp < c2.stamp
+}
+
+type collapseHeap []collapse
+
+func (h collapseHeap) Len() int            { return len(h) }
+func (h collapseHeap) Less(i, j int) bool  { return h[i].less(&h[j]) }
+func (h collapseHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *collapseHeap) Push(x interface{}) { *h = append(*h, x.(collapse)) }
+func (h *collapseHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type decimator struct {
+	pos      []surface.Vector
+	q        []quadric
+	boundary []bool
+	removed  []bool
+	version  []int
+	// tris contains the triangles around each vertex. It may contain removed triangles.
+	tris [][]int
+
+	tri        [][3]int
+	triRemoved []bool
+	count      int
+
+	heap collapseHeap
+}
+
+func newDecimator(m *surface.Mesh) *decimator {
+	n := len(m.Vertex)
+	d := &decimator{
+		pos:        append([]surface.Vector(nil), m.Vertex...),
+		q:          make([]quadric, n),
+		boundary:   make([]bool, n),
+		removed:    make([]bool, n),
+		version:    make([]int, n),
+		tris:       make([][]int, n),
+		tri:        append([][3]int(nil), m.Triangle...),
+		triRemoved: make([]bool, len(m.Triangle)),
+		count:      len(m.Triangle),
+	}
+	// Count the triangles around each edge. The edges, which don't have exactly two,
+	// are the boundary (or the non-manifold) edges. order lists the edges in the order
+	// of the triangles, so the heap is built the same way on every run.
+	edges := make(map[[2]int]int)
+	var order [][2]int
+	for i, t := range d.tri {
+		for j := range t {
+			d.tris[t[j]] = append(d.tris[t[j]], i)
+			e := edgeKey(t[j], t[(j+1)%3])
+			if edges[e] == 0 {
+				order = append(order, e)
+			}
+			edges[e]++
+		}
+		nv, area := normal(d.pos[t[0]], d.pos[t[1]], d.pos[t[2]])
+		if area == 0 {
+			continue
+		}
+		q := planeQuadric(nv, -surface.DotProduct(nv, d.pos[t[0]]))
+		for _, v := range t {
+			d.q[v].add(&q)
+		}
+	}
+	for _, e := range order {
+		if edges[e] != 2 {
+			d.boundary[e[0]] = true
+			d.boundary[e[1]] = true
+		}
+	}
+	for _, e := range order {
+		if c, ok := d.evaluate(e[0], e[1]); ok {
+			d.heap = append(d.heap, c)
+		}
+	}
+	heap.Init(&d.heap)
+	return d
+}
+
+func edgeKey(a, b int) [2]int {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int{a, b}
+}
+
+// evaluate returns the best collapse of the edge uv.
+// The edges between two boundary vertices are never collapsed.
+func (d *decimator) evaluate(u, v int) (c collapse, ok bool) {
+	if d.boundary[u] && d.boundary[v] {
+		return c, false
+	}
+	if d.boundary[u] {
+		u, v = v, u
+	}
+	q := d.q[u]
+	q.add(&d.q[v])
+	c = collapse{u: u, v: v, stamp: d.version[u] + d.version[v]}
+	if d.boundary[v] {
+		c.p = d.pos[v]
+	} else if p, ok := q.minimum(); ok && distance(p, mid(d.pos[u], d.pos[v])) <= distance(d.pos[u], d.pos[v]) {
+		c.p = p
+	} else {
+		c.p = d.pos[v]
+		for _, p := range []surface.Vector{d.pos[u], mid(d.pos[u], d.pos[v])} {
+			if q.eval(p) < q.eval(c.p) {
+				c.p = p
+			}
+		}
+	}
+	c.cost = math.Max(q.eval(c.p), 0)
+	return c, true
+}
+
+func (d *decimator) run(opt Options) {
+	maxCost := math.Inf(1)
+	if opt.MaxError > 0 {
+		maxCost = opt.MaxError * opt.MaxError
+	}
+	// A closed mesh can't have less than four triangles.
+	for d.heap.Len() > 0 && d.count > opt.Target && d.count > 4 {
+		c := heap.Pop(&d.heap).(collapse)
+		if c.cost > maxCost {
+			break
+		}
+		if d.removed[c.u] || d.removed[c.v] || c.stamp != d.version[c.u]+d.version[c.v] {
+			continue
+		}
+		if !d.canCollapse(c) {
+			continue
+		}
+		d.collapse(c)
+	}
+}
+
+// neighbours returns the vertices adjacent to v.
+func (d *decimator) neighbours(v int) map[int]bool {
+	res := make(map[int]bool)
+	for _, t := range d.tris[v] {
+		if d.triRemoved[t] {
+			continue
+		}
+		for _, w := range d.tri[t] {
+			if w != v {
+				res[w] = true
+			}
+		}
+	}
+	return res
+}
+
+func (d *decimator) canCollapse(c collapse) bool {
+	// The link condition: the common neighbours of u and v must be the opposite vertices
+	// of the two triangles of the edge, otherwise the collapse glues the surface.
+	nu, nv := d.neighbours(c.u), d.neighbours(c.v)
+	if !nu[c.v] {
+		return false
+	}
+	common := 0
+	for w := range nu {
+		if nv[w] {
+			common++
+		}
+	}
+	shared := 0
+	for _, t := range d.tris[c.u] {
+		if !d.triRemoved[t] && hasVertex(d.tri[t], c.v) {
+			shared++
+		}
+	}
+	if shared != 2 || common != 2 {
+		return false
+	}
+	// The triangles, which remain, must not flip or degenerate.
+	for _, w := range [2]int{c.u, c.v} {
+		for _, t := range d.tris[w] {
+			tr := d.tri[t]
+			if d.triRemoved[t] || hasVertex(tr, c.u) && hasVertex(tr, c.v) {
+				continue
+			}
+			before, _ := normal(d.pos[tr[0]], d.pos[tr[1]], d.pos[tr[2]])
+			var p [3]surface.Vector
+			for i, x := range tr {
+				p[i] = d.pos[x]
+				if x == w {
+					p[i] = c.p
+				}
+			}
+			after, area := normal(p[0], p[1], p[2])
+			if area == 0 || surface.DotProduct(before, after) < minCos {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// minCos is the cosine of the largest angle, by which a triangle may turn during a collapse.
+const minCos = 0.2
+
+func (d *decimator) collapse(c collapse) {
+	for _, t := range d.tris[c.u] {
+		if d.triRemoved[t] {
+			continue
+		}
+		if hasVertex(d.tri[t], c.v) {
+			d.triRemoved[t] = true
+			d.count--
+			continue
+		}
+		for i, x := range d.tri[t] {
+			if x == c.u {
+				d.tri[t][i] = c.v
+			}
+		}
+		d.tris[c.v] = append(d.tris[c.v], t)
+	}
+	d.removed[c.u] = true
+	d.tris[c.u] = nil
+	d.pos[c.v] = c.p
+	d.q[c.v].add(&d.q[c.u])
+	d.version[c.v]++
+
+	// Drop the removed triangles from the list of v and re-evaluate the edges around it.
+	// The older collapses of these edges are out of date, because the version of v is changed.
+	live := d.tris[c.v][:0]
+	for _, t := range d.tris[c.v] {
+		if !d.triRemoved[t] {
+			live = append(live, t)
+		}
+	}
+	d.tris[c.v] = live
+	for w := range d.neighbours(c.v) {
+		if c, ok := d.evaluate(c.v, w); ok {
+			heap.Push(&d.heap, c)
+		}
+	}
+}
+
+// mesh returns the remaining triangles with their vertices.
+func (d *decimator) mesh() *surface.Mesh {
+	res := new(surface.Mesh)
+	index := make([]int, len(d.pos))
+	for i := range index {
+		index[i] = -1
+	}
+	for t, tr := range d.tri {
+		if d.triRemoved[t] {
+			continue
+		}
+		var cur [3]int
+		for i, v := range tr {
+			if index[v] < 0 {
+				index[v] = len(res.Vertex)
+				res.Vertex = append(res.Vertex, d.pos[v])
+				res.Normal = append(res.Normal, surface.Vector{})
+			}
+			cur[i] = index[v]
+		}
+		res.Triangle = append(res.Triangle, cur)
+	}
+	for _, tr := range res.Triangle {
+		a, b, c := res.Vertex[tr[0]], res.Vertex[tr[1]], res.Vertex[tr[2]]
+		n := surface.CrossProduct(surface.SubVector(b, a), surface.SubVector(c, a))
+		for _, v := range tr {
+			res.Normal[v] = surface.AddVector(res.Normal[v], n)
+		}
+	}
+	for i, n := range res.Normal {
+		if l := math.Sqrt(surface.DotProduct(n, n)); l > 0 {
+			res.Normal[i] = surface.Vector{n.X / l, n.Y / l, n.Z / l}
+		}
+	}
+	return res
+}
+
+func hasVertex(t [3]int, v int) bool {
+	return t[0] == v || t[1] == v || t[2] == v
+}
+
+// normal returns the unit normal and the area of the triangle abc.
+func normal(a, b, c surface.Vector) (n surface.Vector, area float64) {
+	n = surface.CrossProduct(surface.SubVector(b, a), surface.SubVector(c, a))
+	l := math.Sqrt(surface.DotProduct(n, n))
+	if l == 0 {
+		return n, 0
+	}
+	return surface.Vector{n.X / l, n.Y / l, n.Z / l}, l / 2
+}
+
+func mid(a, b surface.Vector) surface.Vector {
+	return surface.Vector{(a.X + b.X) / 2, (a.Y + b.Y) / 2, (a.Z + b.Z) / 2}
+}
+
+func distance(a, b surface.Vector) float64 {
+	d := surface.SubVector(a, b)
+	return math.Sqrt(surface.DotProduct(d, d))
+}
@@ -0,0 +1,157 @@
+package decimate
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/surface"
+)
+
+func sphereMesh(n int) *surface.Mesh {
+	field := func(p g3.Point) float64 {
+		dx, dy, dz := p[0]-0.5, p[1]-0.5, p[2]-0.5
+		return 0.4 - math.Sqrt(dx*dx+dy*dy+dz*dz)
+	}
+	return surface.MarchingCubesMesh(field, n, 0, surface.Vector{1, 1, 1})
+}
+
+// checkClosed checks that every edge is shared by exactly two triangles,
+// which go along it in the opposite directions.
+func checkClosed(t *testing.T, m *surface.Mesh) {
+	edges := make(map[[2]int]int)
+	for _, tr := range m.Triangle {
+		for j := range tr {
+			edges[[2]int{tr[j], tr[(j+1)%3]}]++
+		}
+	}
+	for e, cnt := range edges {
+		if cnt != 1 || edges[[2]int{e[1], e[0]}] != 1 {
+			t.Fatalf("edge %v is used %d times, the opposite one %d times", e, cnt, edges[[2]int{e[1], e[0]}])
+		}
+	}
+}
+
+func signedVolume(m *surface.Mesh) float64 {
+	var vol6 float64
+	for _, tr := range m.Triangle {
+		a, b, c := m.Vertex[tr[0]], m.Vertex[tr[1]], m.Vertex[tr[2]]
+		vol6 += surface.DotProduct(a, surface.CrossProduct(b, c))
+	}
+	return vol6 / 6
+}
+
+func TestDecimateTarget(t *testing.T) {
+	m := sphereMesh(48)
+	target := len(m.Triangle) / 20
+	res := Decimate(m, Options{Target: target})
+	if len(res.Triangle) > target || len(res.Triangle) < target*9/10 {
+		t.Errorf("want about %d triangles, got %d", target, len(res.Triangle))
+	}
+	if len(res.Normal) != len(res.Vertex) {
+		t.Errorf("want %d normals, got %d", len(res.Vertex), len(res.Normal))
+	}
+	checkClosed(t, res)
+	if want, got := signedVolume(m), signedVolume(res); math.Abs(got-want) > 0.02*want {
+		t.Errorf("the volume: want %f, got %f", want, got)
+	}
+	center := surface.Vector{0.5, 0.5, 0.5}
+	for i, tr := range res.Triangle {
+		a, b, c := res.Vertex[tr[0]], res.Vertex[tr[1]], res.Vertex[tr[2]]
+		n := surface.CrossProduct(surface.SubVector(b, a), surface.SubVector(c, a))
+		if surface.DotProduct(n, surface.SubVector(a, center)) <= 0 {
+			t.Fatalf("triangle %d %v is flipped", i, tr)
+		}
+	}
+}
+
+func TestDecimateMaxError(t *testing.T) {
+	m := sphereMesh(32)
+	const maxErr = 0.004
+	res := Decimate(m, Options{MaxError: maxErr})
+	if len(res.Triangle) >= len(m.Triangle)/2 {
+		t.Errorf("want at most %d triangles, got %d", len(m.Triangle)/2, len(res.Triangle))
+	}
+	checkClosed(t, res)
+	for _, v := range res.Vertex {
+		// The marching cubes vertices are within 0.001 from the sphere.
+		if d := math.Abs(distance(v, surface.Vector{0.5, 0.5, 0.5}) - 0.4); d > maxErr+0.001 {
+			t.Errorf("vertex %v is %f away from the sphere", v, d)
+		}
+	}
+	if got := Decimate(m, Options{}); len(got.Triangle) != len(m.Triangle) {
+		t.Errorf("no options: want %d triangles, got %d", len(m.Triangle), len(got.Triangle))
+	}
+}
+
+func TestDecimateBoundary(t *testing.T) {
+	// A bumpy open square of n x n cells.
+	const n = 20
+	m := new(surface.Mesh)
+	for x := 0; x <= n; x++ {
+		for y := 0; y <= n; y++ {
+			z := 0.1 * math.Sin(float64(x)/3) * math.Cos(float64(y)/4)
+			m.Vertex = append(m.Vertex, surface.Vector{float64(x), float64(y), z})
+			m.Normal = append(m.Normal, surface.Vector{0, 0, 1})
+		}
+	}
+	at := func(x, y int) int { return x*(n+1) + y }
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			m.Triangle = append(m.Triangle,
+				[3]int{at(x, y), at(x+1, y), at(x+1, y+1)},
+				[3]int{at(x, y), at(x+1, y+1), at(x, y+1)})
+		}
+	}
+	res := Decimate(m, Options{Target: 100})
+	if len(res.Triangle) >= len(m.Triangle) {
+		t.Fatalf("nothing is decimated")
+	}
+
+	boundary := func(m *surface.Mesh) map[[2]surface.Vector]bool {
+		edges := make(map[[2]int]int)
+		for _, tr := range m.Triangle {
+			for j := range tr {
+				edges[edgeKey(tr[j], tr[(j+1)%3])]++
+			}
+		}
+		res := make(map[[2]surface.Vector]bool)
+		for e, cnt := range edges {
+			if cnt == 1 {
+				res[[2]surface.Vector{m.Vertex[e[0]], m.Vertex[e[1]]}] = true
+				res[[2]surface.Vector{m.Vertex[e[1]], m.Vertex[e[0]]}] = true
+			}
+		}
+		return res
+	}
+	want, got := boundary(m), boundary(res)
+	if len(want) != len(got) {
+		t.Fatalf("boundary: want %d edges, got %d", len(want), len(got))
+	}
+	for e := range want {
+		if !got[e] {
+			t.Errorf("boundary edge %v is lost", e)
+		}
+	}
+	for i, tr := range res.Triangle {
+		a, b, c := res.Vertex[tr[0]], res.Vertex[tr[1]], res.Vertex[tr[2]]
+		if surface.CrossProduct(surface.SubVector(b, a), surface.SubVector(c, a)).Z <= 0 {
+			t.Errorf("triangle %d %v is flipped", i, tr)
+		}
+	}
+}
+
+func TestDecimateDeterministic(t *testing.T) {
+	// The faces of the box are flat, so many collapses cost the same.
+	field := func(p g3.Point) float64 {
+		return 0.3 - math.Max(math.Abs(p[0]-0.5), math.Max(math.Abs(p[1]-0.5), math.Abs(p[2]-0.5)))
+	}
+	m := surface.MarchingCubesMesh(field, 24, 0, surface.Vector{1, 1, 1})
+	want := Decimate(m, Options{Target: len(m.Triangle) / 10})
+	for i := 0; i < 5; i++ {
+		if got := Decimate(m, Options{Target: len(m.Triangle) / 10}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: the result differs from the first one", i+1)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package field
+
+import (
+	"math"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/volume"
+)
+
+// The primitives below are signed distance fields: the value is the distance to the surface,
+// positive inside of the solid and negative outside, so the surface is extracted with the threshold 0.
+// The combinators keep the distance exact or at least not overestimated, which is enough
+// for marching cubes and for Offset to work as expected.
+
+// Sphere returns the ball with the center c and the radius r.
+func Sphere(c g3.Point, r float64) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		return r - length(sub(p, c))
+	}
+}
+
+// Box returns the axis aligned box between min and max.
+func Box(min, max g3.Point) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		// q is the distance to the box along each axis, negative inside.
+		var q, out [3]float64
+		in := math.Inf(-1)
+		for i := range p {
+			q[i] = math.Max(min[i]-p[i], p[i]-max[i])
+			out[i] = math.Max(q[i], 0)
+			in = math.Max(in, q[i])
+		}
+		return -(length(out) + math.Min(in, 0))
+	}
+}
+
+// Cylinder returns the cylinder of radius r, which axis goes from a to b.
+func Cylinder(a, b g3.Point, r float64) g3.ScalarField {
+	axis := sub(b, a)
+	h := length(axis)
+	for i := range axis {
+		axis[i] /= h
+	}
+	return func(p g3.Point) float64 {
+		ap := sub(p, a)
+		t := dot(ap, axis)
+		var radial [3]float64
+		for i := range radial {
+			radial[i] = ap[i] - t*axis[i]
+		}
+		// The distances to the side and to the caps, negative inside.
+		dr := length(radial) - r
+		dh := math.Abs(t-h/2) - h/2
+		return -(math.Min(math.Max(dr, dh), 0) + math.Hypot(math.Max(dr, 0), math.Max(dh, 0)))
+	}
+}
+
+// Torus returns the torus with the center c, which lies in the plane parallel to XY.
+// R is the radius of the central circle of the tube, and r is the radius of the tube.
+func Torus(c g3.Point, R, r float64) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		d := sub(p, c)
+		return r - math.Hypot(math.Hypot(d[0], d[1])-R, d[2])
+	}
+}
+
+// Gyroid returns the gyroid sheet of the given thickness and period, a triply periodic minimal
+// surface used for lattice infills. The distance to the sheet is approximated from the implicit
+// function sin x cos y + sin y cos z + sin z cos x, so it's only close near the sheet.
+func Gyroid(period, thickness float64) g3.ScalarField {
+	k := 2 * math.Pi / period
+	return func(p g3.Point) float64 {
+		x, y, z := p[0]*k, p[1]*k, p[2]*k
+		g := math.Sin(x)*math.Cos(y) + math.Sin(y)*math.Cos(z) + math.Sin(z)*math.Cos(x)
+		// The gradient of g is about 1.5k at the surface.
+		return thickness/2 - math.Abs(g)/(1.5*k)
+	}
+}
+
+// Union returns the union of the solids.
+func Union(fields ...g3.ScalarField) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		res := math.Inf(-1)
+		for _, f := range fields {
+			res = math.Max(res, f(p))
+		}
+		return res
+	}
+}
+
+// Intersection returns the intersection of the solids.
+func Intersection(fields ...g3.ScalarField) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		res := math.Inf(1)
+		for _, f := range fields {
+			res = math.Min(res, f(p))
+		}
+		return res
+	}
+}
+
+// Difference returns the solid a without the solid b.
+func Difference(a, b g3.ScalarField) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		return math.Min(a(p), -b(p))
+	}
+}
+
+// SmoothUnion returns the union of the solids, where the seam is filled with a fillet.
+// The fillet spans the points, where the distances to the two solids differ by less than k.
+func SmoothUnion(a, b g3.ScalarField, k float64) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		va, vb := a(p), b(p)
+		h := math.Max(k-math.Abs(va-vb), 0) / k
+		return math.Max(va, vb) + h*h*k/4
+	}
+}
+
+// Offset grows the solid by d. Negative d shrinks it.
+func Offset(f g3.ScalarField, d float64) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		return f(p) + d
+	}
+}
+
+// Translate moves the solid by v.
+func Translate(f g3.ScalarField, v g3.Vector) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		return f(g3.Point{p[0] - v[0], p[1] - v[1], p[2] - v[2]})
+	}
+}
+
+// Scale scales the solid by k relative to the origin.
+func Scale(f g3.ScalarField, k float64) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		return f(g3.Point{p[0] / k, p[1] / k, p[2] / k}) * k
+	}
+}
+
+// Rotate rotates the solid around the axis through the origin by the angle in radians.
+// The rotation is counterclockwise when viewed from the end of the axis.
+func Rotate(f g3.ScalarField, axis g3.Vector, angle float64) g3.ScalarField {
+	l := math.Sqrt(axis[0]*axis[0] + axis[1]*axis[1] + axis[2]*axis[2])
+	u := [3]float64{axis[0] / l, axis[1] / l, axis[2] / l}
+	// The point is rotated back by the angle with the Rodrigues' formula.
+	sin, cos := math.Sin(-angle), math.Cos(-angle)
+	return func(p g3.Point) float64 {
+		v := [3]float64(p)
+		c := [3]float64{
+			u[1]*v[2] - u[2]*v[1],
+			u[2]*v[0] - u[0]*v[2],
+			u[0]*v[1] - u[1]*v[0],
+		}
+		d := dot(u, v) * (1 - cos)
+		var q g3.Point
+		for i := range q {
+			q[i] = v[i]*cos + c[i]*sin + u[i]*d
+		}
+		return f(q)
+	}
+}
+
+// Repeat repeats the solid with the given period along each axis. The copies are centered
+// at the multiples of the period, and only the part of the solid within half of the period
+// from the origin is repeated. Zero period means no repetition along the axis.
+func Repeat(f g3.ScalarField, period g3.Vector) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		for i := range p {
+			if period[i] != 0 {
+				p[i] -= period[i] * math.Floor(p[i]/period[i]+0.5)
+			}
+		}
+		return f(p)
+	}
+}
+
+// Voxelize returns the volume of side n, where the voxels with the centers inside of the solid f
+// have the value 1. f is defined over the unit cube like the fields of surface.MarchingCubes.
+// f is sampled concurrently, so it must be safe for concurrent use.
+func Voxelize(f g3.ScalarField, n int) *volume.SparseVolume {
+	vol := volume.NewSparseVolume(n)
+	s := Sample(func(p g3.Point) float64 {
+		// The samples are moved to the centers of the voxels.
+		h := 0.5 / float64(n)
+		return f(g3.Point{p[0] + h, p[1] + h, p[2] + h})
+	}, n, 0)
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			for z := 0; z < n; z++ {
+				if s.V[s.index(x, y, z)] > 0 {
+					vol.Set16(g3.Node{x, y, z}, 1)
+				}
+			}
+		}
+	}
+	return vol
+}
+
+func sub(a, b g3.Point) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func length(a [3]float64) float64 {
+	return math.Sqrt(dot(a, a))
+}
@@ -0,0 +1,97 @@
+package field
+
+import (
+	"math"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/surface"
+)
+
+func TestPrimitives(t *testing.T) {
+	tests := []struct {
+		name string
+		f    g3.ScalarField
+		p    g3.Point
+		want float64
+	}{
+		{"sphere center", Sphere(g3.Point{1, 2, 3}, 2), g3.Point{1, 2, 3}, 2},
+		{"sphere outside", Sphere(g3.Point{1, 2, 3}, 2), g3.Point{1, 2, 8}, -3},
+		{"box inside", Box(g3.Point{0, 0, 0}, g3.Point{4, 2, 6}), g3.Point{1, 1, 3}, 1},
+		{"box face", Box(g3.Point{0, 0, 0}, g3.Point{4, 2, 6}), g3.Point{5, 1, 3}, -1},
+		{"box corner", Box(g3.Point{0, 0, 0}, g3.Point{4, 2, 6}), g3.Point{7, 6, 6}, -5},
+		{"cylinder axis", Cylinder(g3.Point{0, 0, 0}, g3.Point{0, 0, 10}, 3), g3.Point{0, 0, 4}, 3},
+		{"cylinder side", Cylinder(g3.Point{0, 0, 0}, g3.Point{0, 0, 10}, 3), g3.Point{5, 0, 5}, -2},
+		{"cylinder cap", Cylinder(g3.Point{0, 0, 0}, g3.Point{0, 0, 10}, 3), g3.Point{1, 1, -2}, -2},
+		{"cylinder rim", Cylinder(g3.Point{0, 0, 0}, g3.Point{0, 0, 10}, 3), g3.Point{6, 0, 14}, -5},
+		{"torus tube", Torus(g3.Point{0, 0, 0}, 5, 1), g3.Point{0, 5, 0}, 1},
+		{"torus hole", Torus(g3.Point{0, 0, 0}, 5, 1), g3.Point{0, 0, 0}, -4},
+		{"gyroid sheet", Gyroid(1, 0.2), g3.Point{0, 0, 0}, 0.1},
+	}
+	for _, tt := range tests {
+		if got := tt.f(tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: want %f, got %f", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	a := Sphere(g3.Point{0, 0, 0}, 2)
+	b := Sphere(g3.Point{3, 0, 0}, 2)
+	tests := []struct {
+		name string
+		f    g3.ScalarField
+		p    g3.Point
+		want float64
+	}{
+		{"union", Union(a, b), g3.Point{4, 0, 0}, 1},
+		{"intersection", Intersection(a, b), g3.Point{1.5, 0, 0}, 0.5},
+		{"intersection outside", Intersection(a, b), g3.Point{-1, 0, 0}, -2},
+		{"difference", Difference(a, b), g3.Point{-1, 0, 0}, 1},
+		{"difference hole", Difference(a, b), g3.Point{1.5, 0, 0}, -0.5},
+		{"smooth union far", SmoothUnion(a, b, 1), g3.Point{-1, 0, 0}, 1},
+		{"smooth union seam", SmoothUnion(a, b, 1), g3.Point{1.5, 3, 0}, 2 - math.Hypot(1.5, 3) + 0.25},
+		{"offset", Offset(a, 1), g3.Point{0, 2.5, 0}, 0.5},
+		{"translate", Translate(a, g3.Vector{1, 1, 1}), g3.Point{1, 1, 1}, 2},
+		{"scale", Scale(a, 3), g3.Point{0, 0, 7}, -1},
+		{"rotate", Rotate(b, g3.Vector{0, 0, 1}, math.Pi/2), g3.Point{0, 3, 0}, 2},
+		{"repeat", Repeat(a, g3.Vector{10, 0, 0}), g3.Point{31, 0, 0}, 1},
+		{"repeat no period", Repeat(a, g3.Vector{10, 0, 0}), g3.Point{0, 10, 0}, -8},
+	}
+	for _, tt := range tests {
+		if got := tt.f(tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: want %f, got %f", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCSGMesh(t *testing.T) {
+	// A box with a cylindrical hole and a ball above it.
+	f := Union(
+		Difference(
+			Box(g3.Point{0.2, 0.2, 0.2}, g3.Point{0.8, 0.8, 0.5}),
+			Cylinder(g3.Point{0.5, 0.5, 0}, g3.Point{0.5, 0.5, 1}, 0.1)),
+		Sphere(g3.Point{0.5, 0.5, 0.7}, 0.15))
+	mesh := surface.MarchingCubesMesh(f, 64, 0, surface.Vector{X: 1, Y: 1, Z: 1})
+	var vol6 float64
+	for _, tr := range mesh.Triangle {
+		a, b, c := mesh.Vertex[tr[0]], mesh.Vertex[tr[1]], mesh.Vertex[tr[2]]
+		vol6 += a.X*(b.Y*c.Z-b.Z*c.Y) + a.Y*(b.Z*c.X-b.X*c.Z) + a.Z*(b.X*c.Y-b.Y*c.X)
+	}
+	want := 0.6*0.6*0.3 - math.Pi*0.1*0.1*0.3 + 4./3*math.Pi*0.15*0.15*0.15
+	if got := vol6 / 6; math.Abs(got-want) > 0.02*want {
+		t.Errorf("the volume: want %f, got %f", want, got)
+	}
+}
+
+func TestVoxelize(t *testing.T) {
+	const n = 64
+	vol := Voxelize(Sphere(g3.Point{0.5, 0.5, 0.5}, 0.3), n)
+	want := 4. / 3 * math.Pi * 0.3 * 0.3 * 0.3 * n * n * n
+	if got := float64(vol.Volume()); math.Abs(got-want) > 0.02*want {
+		t.Errorf("the volume: want %f voxels, got %f", want, got)
+	}
+	if !vol.Get(g3.Node{32, 32, 32}) || vol.Get(g3.Node{2, 32, 32}) {
+		t.Errorf("the center must be filled and the corner must be empty")
+	}
+}
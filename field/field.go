@@ -0,0 +1,96 @@
+// Package field contains scalar fields for the surface reconstruction and the solid modelling.
+//
+// The fields are defined over the unit cube like the fields of surface.MarchingCubes.
+// The voxel (x, y, z) of the volume with side n occupies the cube [x/n, (x+1)/n] × [y/n, (y+1)/n] × [z/n, (z+1)/n].
+package field
+
+import (
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/krasin/g3"
+)
+
+// Sampled is a scalar field sampled on a regular grid over the unit cube
+// and trilinearly interpolated between the samples.
+type Sampled struct {
+	// N is the number of cells along each axis. There are N+1 samples along each axis.
+	N int
+	// V contains the samples. V[(x*(N+1)+y)*(N+1)+z] is the value at (x, y, z) / N.
+	V []float64
+}
+
+// Sample samples the field on the grid with n cells along each axis.
+// The samples are taken with the given number of goroutines.
+// If workers <= 0, runtime.NumCPU() is used. The field must be safe for concurrent use.
+func Sample(f g3.ScalarField, n, workers int) *Sampled {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	s := &Sampled{N: n, V: make([]float64, (n+1)*(n+1)*(n+1))}
+	step := 1 / float64(n)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for x := w; x <= n; x += workers {
+				for y := 0; y <= n; y++ {
+					for z := 0; z <= n; z++ {
+						s.V[s.index(x, y, z)] = f(g3.Point{float64(x) * step, float64(y) * step, float64(z) * step})
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return s
+}
+
+func (s *Sampled) index(x, y, z int) int {
+	return (x*(s.N+1)+y)*(s.N+1) + z
+}
+
+// At returns the value of the field at p. Outside of the unit cube,
+// the value at the nearest point of the unit cube is returned.
+func (s *Sampled) At(p g3.Point) float64 {
+	var i [3]int
+	var t [3]float64
+	for axis, v := range p {
+		v *= float64(s.N)
+		if v < 0 {
+			v = 0
+		}
+		if v > float64(s.N) {
+			v = float64(s.N)
+		}
+		fl := math.Floor(v)
+		if fl == float64(s.N) {
+			fl--
+		}
+		i[axis] = int(fl)
+		t[axis] = v - fl
+	}
+	var res float64
+	for dx := 0; dx < 2; dx++ {
+		wx := 1 - t[0]
+		if dx == 1 {
+			wx = t[0]
+		}
+		for dy := 0; dy < 2; dy++ {
+			wy := 1 - t[1]
+			if dy == 1 {
+				wy = t[1]
+			}
+			for dz := 0; dz < 2; dz++ {
+				wz := 1 - t[2]
+				if dz == 1 {
+					wz = t[2]
+				}
+				res += wx * wy * wz * s.V[s.index(i[0]+dx, i[1]+dy, i[2]+dz)]
+			}
+		}
+	}
+	return res
+}
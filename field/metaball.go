@@ -0,0 +1,175 @@
+package field
+
+import (
+	"math"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/volume"
+)
+
+// MetaballThreshold is the threshold for the field built by NewMetaballs.
+// Near a flat part of the solid, the field crosses it at the original surface.
+const MetaballThreshold = 0.07
+
+// Depth returns the depths of the filled voxels of vol up to max. The depth is
+// the Chebyshev distance to the nearest empty voxel, so the voxels, which have an empty
+// voxel among their 26 neighbours, have the depth 1. The deeper voxels get max+1,
+// and the empty voxels get 0. The result has the same size as vol.
+func Depth(vol volume.Space, max int) *volume.SparseVolume {
+	size := vol.Size()
+	res := volume.NewSparseVolumeSize(size)
+	var q, q2 []g3.Node
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				node := g3.Node{x, y, z}
+				if !vol.Get(node) {
+					continue
+				}
+				res.Set16(node, uint16(max+1))
+				for _, v := range g3.AdjNodes26 {
+					if !vol.Get(node.Add(v)) {
+						q = append(q, node)
+						res.Set16(node, 1)
+						break
+					}
+				}
+			}
+		}
+	}
+	for d := 2; d <= max && len(q) > 0; d++ {
+		q, q2 = q2[:0], q
+		for _, node := range q2 {
+			for _, v := range g3.AdjNodes26 {
+				cur := node.Add(v)
+				if int(res.Get16(cur)) > d {
+					res.Set16(cur, uint16(d))
+					q = append(q, cur)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Metaballs is the sum of the metaballs with the kernel (1 - d²/r0²)² placed
+// at the centers of the deep voxels of a solid. It's the first step of the reconstruction
+// proposed in plan.txt: the voxels deeper than r are found, so the union of them is
+// the solid eroded by r, and the metaballs with r0 = 2r are drawn around them.
+// The sum is normalized by the integral of the kernel, so the field is about 1 deep
+// inside of the solid, about 0.5 at the depth r and about
+// MetaballThreshold at the original surface of the flat parts of the solid.
+// The thin parts of the solid, which have no voxels at the depth r, disappear.
+type Metaballs struct {
+	n     int
+	depth *volume.SparseVolume
+	// r0 is the radius of the metaballs in voxels.
+	r0 float64
+	// The field is considered to be 1 in the voxels deeper than sat:
+	// all voxels within r0 from them are deeper than r.
+	sat uint16
+	// norm is the inverse of the integral of the kernel.
+	norm float64
+
+	// The centers of the metaballs are bucketed into the cells of side r0,
+	// so only 27 cells are looked at for any point.
+	// The centers in the cell i are center[start[i]:start[i+1]].
+	cells  int
+	start  []int
+	center [][3]float64
+}
+
+// NewMetaballs returns the metaballs field of the solid vol for the radius r (in voxels).
+func NewMetaballs(vol volume.Space, r int) *Metaballs {
+	if r < 1 {
+		r = 1
+	}
+	r0 := 2 * r
+	m := &Metaballs{
+		n:    vol.N(),
+		r0:   float64(r0),
+		sat:  uint16(r + r0 + 1),
+		norm: 105 / (32 * math.Pi * float64(r0*r0*r0)),
+	}
+	// The metaballs within r0 of a voxel, which is not deeper than sat, are not deeper than sat+r0+1.
+	// The deeper ones are not needed.
+	maxDepth := int(m.sat) + r0 + 1
+	m.depth = Depth(vol, maxDepth)
+
+	m.cells = (m.n + r0 - 1) / r0
+	m.start = make([]int, m.cells*m.cells*m.cells+1)
+	var nodes []g3.Node
+	for x := 0; x < m.n; x++ {
+		for y := 0; y < m.n; y++ {
+			for z := 0; z < m.n; z++ {
+				node := g3.Node{x, y, z}
+				if d := int(m.depth.Get16(node)); d > r && d <= maxDepth {
+					nodes = append(nodes, node)
+					m.start[m.cell(x/r0, y/r0, z/r0)+1]++
+				}
+			}
+		}
+	}
+	for i := 1; i < len(m.start); i++ {
+		m.start[i] += m.start[i-1]
+	}
+	m.center = make([][3]float64, len(nodes))
+	next := append([]int(nil), m.start[:len(m.start)-1]...)
+	for _, node := range nodes {
+		c := m.cell(node[0]/r0, node[1]/r0, node[2]/r0)
+		m.center[next[c]] = [3]float64{float64(node[0]) + 0.5, float64(node[1]) + 0.5, float64(node[2]) + 0.5}
+		next[c]++
+	}
+	return m
+}
+
+func (m *Metaballs) cell(x, y, z int) int {
+	return (x*m.cells+y)*m.cells + z
+}
+
+// At returns the value of the field at p.
+func (m *Metaballs) At(p g3.Point) float64 {
+	var v [3]float64
+	var node g3.Node
+	for i := range p {
+		v[i] = p[i] * float64(m.n)
+		node[i] = int(math.Floor(v[i]))
+	}
+	if m.depth.Get16(node) > m.sat {
+		return 1
+	}
+	r2 := m.r0 * m.r0
+	var sum float64
+	for cx := int(math.Floor(v[0]/m.r0)) - 1; cx <= int(math.Floor(v[0]/m.r0))+1; cx++ {
+		if cx < 0 || cx >= m.cells {
+			continue
+		}
+		for cy := int(math.Floor(v[1]/m.r0)) - 1; cy <= int(math.Floor(v[1]/m.r0))+1; cy++ {
+			if cy < 0 || cy >= m.cells {
+				continue
+			}
+			for cz := int(math.Floor(v[2]/m.r0)) - 1; cz <= int(math.Floor(v[2]/m.r0))+1; cz++ {
+				if cz < 0 || cz >= m.cells {
+					continue
+				}
+				c := m.cell(cx, cy, cz)
+				for _, center := range m.center[m.start[c]:m.start[c+1]] {
+					dx, dy, dz := v[0]-center[0], v[1]-center[1], v[2]-center[2]
+					d2 := dx*dx + dy*dy + dz*dz
+					if d2 < r2 {
+						k := 1 - d2/r2
+						sum += k * k
+					}
+				}
+			}
+		}
+	}
+	return sum * m.norm
+}
+
+// MetaballField returns the metaballs field of the solid vol for the radius r
+// sampled on the grid with n cells along each axis, see NewMetaballs.
+// The result can be passed to surface.MarchingCubes with the same n and MetaballThreshold.
+func MetaballField(vol volume.Space, r, n int) *Sampled {
+	return Sample(NewMetaballs(vol, r).At, n, 0)
+}
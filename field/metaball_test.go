@@ -0,0 +1,106 @@
+package field
+
+import (
+	"math"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/surface"
+	"github.com/krasin/voxel/volume"
+)
+
+// box returns the volume of side n, where the voxels in [lo, hi) along each axis are filled.
+func box(n int, lo, hi [3]int) *volume.SparseVolume {
+	vol := volume.NewSparseVolume(n)
+	for x := lo[0]; x < hi[0]; x++ {
+		for y := lo[1]; y < hi[1]; y++ {
+			for z := lo[2]; z < hi[2]; z++ {
+				vol.Set16(g3.Node{x, y, z}, 1)
+			}
+		}
+	}
+	return vol
+}
+
+func TestDepth(t *testing.T) {
+	vol := box(64, [3]int{10, 10, 10}, [3]int{30, 40, 50})
+	depth := Depth(vol, 6)
+	tests := []struct {
+		node g3.Node
+		want uint16
+	}{
+		{g3.Node{9, 20, 20}, 0},
+		{g3.Node{10, 20, 20}, 1},
+		{g3.Node{10, 10, 10}, 1},
+		{g3.Node{12, 11, 20}, 2},
+		{g3.Node{14, 14, 14}, 5},
+		{g3.Node{15, 20, 20}, 6},
+		{g3.Node{16, 20, 20}, 7},
+		{g3.Node{20, 25, 30}, 7},
+		{g3.Node{29, 25, 30}, 1},
+	}
+	for _, tt := range tests {
+		if got := depth.Get16(tt.node); got != tt.want {
+			t.Errorf("depth at %v: want %d, got %d", tt.node, tt.want, got)
+		}
+	}
+}
+
+func TestSampled(t *testing.T) {
+	f := func(p g3.Point) float64 {
+		return 1 + 2*p[0] - 3*p[1] + 0.5*p[2]
+	}
+	s := Sample(f, 10, 3)
+	for _, p := range []g3.Point{{0, 0, 0}, {0.33, 0.71, 0.05}, {1, 1, 1}, {0.999, 0.5, 0.123}} {
+		if got, want := s.At(p), f(p); math.Abs(got-want) > 1e-9 {
+			t.Errorf("At(%v): want %f, got %f", p, want, got)
+		}
+	}
+	if got, want := s.At(g3.Point{-1, 0.5, 2}), f(g3.Point{0, 0.5, 1}); math.Abs(got-want) > 1e-9 {
+		t.Errorf("At outside of the unit cube: want %f, got %f", want, got)
+	}
+}
+
+func TestMetaballs(t *testing.T) {
+	const n = 64
+	lo, hi := [3]int{12, 16, 20}, [3]int{52, 48, 44}
+	vol := box(n, lo, hi)
+	const r = 3
+	m := NewMetaballs(vol, r)
+
+	at := func(x, y, z float64) float64 {
+		return m.At(g3.Point{x / n, y / n, z / n})
+	}
+	if v := at(32, 32, 32); math.Abs(v-1) > 0.05 {
+		t.Errorf("deep inside: want about 1, got %f", v)
+	}
+	if v := at(4, 32, 32); v != 0 {
+		t.Errorf("far outside: want 0, got %f", v)
+	}
+	// The center of the face of the box.
+	if v := at(float64(lo[0]), 32, 32); math.Abs(v-MetaballThreshold) > 0.02 {
+		t.Errorf("at the face: want about %f, got %f", MetaballThreshold, v)
+	}
+	// The field is monotonic along the normal to the face.
+	prev := 0.0
+	for x := 4.0; x <= 32; x += 0.5 {
+		v := at(x, 32, 32)
+		if v < prev-1e-3 {
+			t.Errorf("the field decreases from %f to %f at x=%f", prev, v, x)
+		}
+		prev = v
+	}
+
+	s := MetaballField(vol, r, n)
+	mesh := surface.MarchingCubesMesh(s.At, n, MetaballThreshold, surface.Vector{n, n, n})
+	var vol6 float64
+	for _, tr := range mesh.Triangle {
+		a, b, c := mesh.Vertex[tr[0]], mesh.Vertex[tr[1]], mesh.Vertex[tr[2]]
+		vol6 += a.X*(b.Y*c.Z-b.Z*c.Y) + a.Y*(b.Z*c.X-b.X*c.Z) + a.Z*(b.X*c.Y-b.Y*c.X)
+	}
+	// The edges and the corners of the box are rounded, so some volume is lost.
+	want := float64((hi[0] - lo[0]) * (hi[1] - lo[1]) * (hi[2] - lo[2]))
+	if got := vol6 / 6; got > want || got < 0.85*want {
+		t.Errorf("the volume of the surface: want about %f, got %f", want, got)
+	}
+}
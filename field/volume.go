@@ -0,0 +1,388 @@
+package field
+
+import (
+	"math"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/volume"
+)
+
+// NewVolumeField returns the field, which is 100 in the filled voxels of vol and 0 elsewhere.
+func NewVolumeField(vol volume.Space16) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		for _, v := range p {
+			if v <= 0 || v >= 1 {
+				return 0
+			}
+		}
+		xx := int(p[0] * float64(vol.N()))
+		yy := int(p[1] * float64(vol.N()))
+		zz := int(p[2] * float64(vol.N()))
+		val := vol.Get(g3.Node{xx, yy, zz})
+		if val {
+			return 100
+		}
+		return 0
+	}
+}
+
+type adj struct {
+	dx, dy, dz int
+	weight     float64
+}
+
+// cells are the voxels, which contribute to NewVolumeField2, relative to the voxel of the point.
+var cells = []adj{
+	{0, 0, 0, 0.85},
+	{1, 0, 0, 0.5},
+	{0, 1, 0, 0.5},
+	{0, 0, 1, 0.5},
+	{-1, 0, 0, 0.5},
+	{0, -1, 0, 0.5},
+	{0, 0, -1, 0.5},
+	{1, 1, 0, 0.25},
+	{1, 0, 1, 0.25},
+	{0, 1, 1, 0.25},
+	{-1, 1, 0, 0.25},
+	{-1, 0, 1, 0.25},
+	{0, -1, 1, 0.25},
+	{1, -1, 0, 0.25},
+	{1, 0, -1, 0.25},
+	{0, 1, -1, 0.25},
+	{-1, -1, 0, 0.25},
+	{-1, 0, -1, 0.25},
+	{0, -1, -1, 0.25},
+}
+
+// NewVolumeField2 returns the field, which is the sum of the weighted inverse squared distances
+// to the centers of the filled voxels among the 18 neighbours of the point's voxel and the voxel itself.
+// The surface of a solid is about 0.8.
+func NewVolumeField2(vol volume.Space16) g3.ScalarField {
+	return func(p g3.Point) float64 {
+		for _, v := range p {
+			if v <= 0 || v >= 1 {
+				return 0
+			}
+		}
+
+		fx := p[0] * float64(vol.N())
+		fy := p[1] * float64(vol.N())
+		fz := p[2] * float64(vol.N())
+
+		xx := int(fx)
+		yy := int(fy)
+		zz := int(fz)
+
+		dx := fx - float64(xx) - 0.5
+		dy := fy - float64(yy) - 0.5
+		dz := fz - float64(zz) - 0.5
+
+		var val float64
+		for _, cell := range cells {
+			var v float64
+			if vol.Get(g3.Node{xx + cell.dx, yy + cell.dy, zz + cell.dz}) {
+				v = 1
+			}
+			ddx := dx - float64(cell.dx)
+			ddy := dy - float64(cell.dy)
+			ddz := dz - float64(cell.dz)
+			r2 := ddx*ddx + ddy*ddy + ddz*ddz + 0.1
+			val += cell.weight * v / r2
+		}
+		return val
+	}
+}
+
+// World maps the field defined over the unit cube to the cube of the grid.
+func World(field g3.ScalarField, g g3.Grid) g3.ScalarField {
+	side := g.Side()
+	return func(p g3.Point) float64 {
+		return field(g3.Point{(p[0] - g.P0[0]) / side, (p[1] - g.P0[1]) / side, (p[2] - g.P0[2]) / side})
+	}
+}
+
+// Voxels interpolates the occupancy of the voxels: it's 1 at the centers of the filled voxels
+// and 0 at the centers of the empty ones, so the surface of the solid is at 0.5.
+// The voxels outside of the volume are empty.
+type Voxels struct {
+	vol volume.Space16
+	n   float64
+	// blurred contains the blurred occupancy of the voxels near the boundary of the solid.
+	// The other voxels keep their occupancy. It's nil if there's no blur.
+	blurred *band
+}
+
+// NewVoxels returns the interpolator of vol. If sigma > 0, the occupancy is blurred
+// with the Gaussian kernel first. sigma is measured in voxels.
+func NewVoxels(vol volume.Space16, sigma float64) *Voxels {
+	v := &Voxels{vol: vol, n: float64(vol.N())}
+	if sigma > 0 {
+		v.blurred = blur(vol, sigma)
+	}
+	return v
+}
+
+// band keeps the values of the voxels near the boundary of the solid in the dense leaf cubes
+// of side volume.LeafSide. The cubes, which are far from the boundary, are nil.
+// The band covers the volume and off voxels around it.
+type band struct {
+	off    int
+	cubes  g3.Node
+	leaves [][]float64
+}
+
+// leaf returns the index of the leaf cube of node and the index of the voxel in it,
+// or -1 if node is out of the band.
+func (b *band) leaf(node g3.Node) (k, h int) {
+	for i := range node {
+		node[i] += b.off
+		if node[i] < 0 || node[i] >= b.cubes[i]*volume.LeafSide {
+			return -1, 0
+		}
+	}
+	k = ((node[0]/volume.LeafSide)*b.cubes[1]+node[1]/volume.LeafSide)*b.cubes[2] + node[2]/volume.LeafSide
+	h = ((node[0]%volume.LeafSide)*volume.LeafSide+node[1]%volume.LeafSide)*volume.LeafSide + node[2]%volume.LeafSide
+	return
+}
+
+// get returns the value of node and whether it's in the band.
+func (b *band) get(node g3.Node) (float64, bool) {
+	k, h := b.leaf(node)
+	if k < 0 || b.leaves[k] == nil {
+		return 0, false
+	}
+	return b.leaves[k][h], true
+}
+
+// blur returns the occupancy of the voxels near the boundary of the solid blurred with the Gaussian kernel.
+// The kernel is cut at 3 sigma, so the voxels farther than that from the boundary don't change.
+// The kernel is separable, so the blur of each leaf cube is done along one axis at a time.
+func blur(vol volume.Space16, sigma float64) *band {
+	r := int(math.Ceil(3 * sigma))
+	w := make([]float64, 2*r+1)
+	var sum float64
+	for i := range w {
+		d := float64(i - r)
+		w[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += w[i]
+	}
+	for i := range w {
+		w[i] /= sum
+	}
+
+	// The empty boundary voxels are next to the filled ones, so the changed voxels are within r+1
+	// from the filled boundary voxels, and the band is that much larger than the volume.
+	b := &band{off: r + 1}
+	size := vol.Size()
+	for i := range size {
+		b.cubes[i] = (size[i] + 2*b.off + volume.LeafSide - 1) / volume.LeafSide
+	}
+	b.leaves = make([][]float64, b.cubes[0]*b.cubes[1]*b.cubes[2])
+	vol.MapBoundary(func(node g3.Node) {
+		var lo, hi g3.Node
+		for i := range node {
+			lo[i] = (node[i] + b.off - r - 1) / volume.LeafSide
+			hi[i] = (node[i] + b.off + r + 1) / volume.LeafSide
+			if hi[i] >= b.cubes[i] {
+				hi[i] = b.cubes[i] - 1
+			}
+		}
+		for x := lo[0]; x <= hi[0]; x++ {
+			for y := lo[1]; y <= hi[1]; y++ {
+				for z := lo[2]; z <= hi[2]; z++ {
+					if k := (x*b.cubes[1]+y)*b.cubes[2] + z; b.leaves[k] == nil {
+						b.leaves[k] = make([]float64, volume.LeafSide*volume.LeafSide*volume.LeafSide)
+					}
+				}
+			}
+		}
+	})
+
+	// Each leaf cube is blurred from the occupancy of the cube r voxels larger on every side:
+	// src holds it, and the passes along z, y and x shrink it to the leaf cube. The passes also count
+	// the filled voxels within the kernel, so the voxels with the uniform neighbourhood keep their occupancy exactly.
+	const s = volume.LeafSide
+	m := s + 2*r
+	full := float64((2*r + 1) * (2*r + 1) * (2*r + 1))
+	src := make([]float64, m*m*m)
+	zbuf, zcnt := make([]float64, m*m*s), make([]float64, m*m*s)
+	ybuf, ycnt := make([]float64, m*s*s), make([]float64, m*s*s)
+	for k, leaf := range b.leaves {
+		if leaf == nil {
+			continue
+		}
+		var p g3.Node
+		p[0] = k/(b.cubes[1]*b.cubes[2])*s - b.off - r
+		p[1] = k/b.cubes[2]%b.cubes[1]*s - b.off - r
+		p[2] = k%b.cubes[2]*s - b.off - r
+		for x := 0; x < m; x++ {
+			for y := 0; y < m; y++ {
+				for z := 0; z < m; z++ {
+					src[(x*m+y)*m+z] = 0
+					if vol.Get(g3.Node{p[0] + x, p[1] + y, p[2] + z}) {
+						src[(x*m+y)*m+z] = 1
+					}
+				}
+			}
+		}
+		for x := 0; x < m; x++ {
+			for y := 0; y < m; y++ {
+				for z := 0; z < s; z++ {
+					var v, c float64
+					for i, wi := range w {
+						v += wi * src[(x*m+y)*m+z+i]
+						c += src[(x*m+y)*m+z+i]
+					}
+					zbuf[(x*m+y)*s+z], zcnt[(x*m+y)*s+z] = v, c
+				}
+			}
+		}
+		for x := 0; x < m; x++ {
+			for y := 0; y < s; y++ {
+				for z := 0; z < s; z++ {
+					var v, c float64
+					for i, wi := range w {
+						v += wi * zbuf[(x*m+y+i)*s+z]
+						c += zcnt[(x*m+y+i)*s+z]
+					}
+					ybuf[(x*s+y)*s+z], ycnt[(x*s+y)*s+z] = v, c
+				}
+			}
+		}
+		for x := 0; x < s; x++ {
+			for y := 0; y < s; y++ {
+				for z := 0; z < s; z++ {
+					var v, c float64
+					for i, wi := range w {
+						v += wi * ybuf[((x+i)*s+y)*s+z]
+						c += ycnt[((x+i)*s+y)*s+z]
+					}
+					switch c {
+					case 0:
+						v = 0
+					case full:
+						v = 1
+					}
+					leaf[(x*s+y)*s+z] = v
+				}
+			}
+		}
+	}
+	return b
+}
+
+func (v *Voxels) value(node g3.Node) float64 {
+	if v.blurred != nil {
+		if val, ok := v.blurred.get(node); ok {
+			return val
+		}
+	}
+	if v.vol.Get(node) {
+		return 1
+	}
+	return 0
+}
+
+// base returns the voxel and the offset of p from its center in voxels.
+func (v *Voxels) base(p g3.Point) (node g3.Node, t [3]float64) {
+	for i := range p {
+		u := p[i]*v.n - 0.5
+		fl := math.Floor(u)
+		node[i] = int(fl)
+		t[i] = u - fl
+	}
+	return
+}
+
+// Trilinear returns the trilinear interpolation of the occupancy at p.
+func (v *Voxels) Trilinear(p g3.Point) float64 {
+	val, _ := v.trilinear(p)
+	return val
+}
+
+// TrilinearGradient returns the gradient of the trilinear interpolation at p.
+// It's discontinuous at the planes through the centers of the voxels.
+func (v *Voxels) TrilinearGradient(p g3.Point) g3.Vector {
+	_, grad := v.trilinear(p)
+	return grad
+}
+
+func (v *Voxels) trilinear(p g3.Point) (val float64, grad g3.Vector) {
+	node, t := v.base(p)
+	var w, dw [3][2]float64
+	for i := range t {
+		w[i] = [2]float64{1 - t[i], t[i]}
+		dw[i] = [2]float64{-v.n, v.n}
+	}
+	for dx := 0; dx < 2; dx++ {
+		for dy := 0; dy < 2; dy++ {
+			for dz := 0; dz < 2; dz++ {
+				s := v.value(g3.Node{node[0] + dx, node[1] + dy, node[2] + dz})
+				val += w[0][dx] * w[1][dy] * w[2][dz] * s
+				grad[0] += dw[0][dx] * w[1][dy] * w[2][dz] * s
+				grad[1] += w[0][dx] * dw[1][dy] * w[2][dz] * s
+				grad[2] += w[0][dx] * w[1][dy] * dw[2][dz] * s
+			}
+		}
+	}
+	return
+}
+
+// Tricubic returns the cubic B-spline interpolation of the occupancy at p.
+// The B-spline smooths the staircase of the voxels, and its gradient is continuous.
+// Like with any B-spline, the value at the center of the voxel is affected by its neighbours.
+func (v *Voxels) Tricubic(p g3.Point) float64 {
+	val, _ := v.tricubic(p)
+	return val
+}
+
+// TricubicGradient returns the gradient of the cubic B-spline interpolation at p.
+func (v *Voxels) TricubicGradient(p g3.Point) g3.Vector {
+	_, grad := v.tricubic(p)
+	return grad
+}
+
+func (v *Voxels) tricubic(p g3.Point) (val float64, grad g3.Vector) {
+	node, t := v.base(p)
+	var w, dw [3][4]float64
+	for i := range t {
+		w[i], dw[i] = bspline(t[i])
+		for j := range dw[i] {
+			dw[i][j] *= v.n
+		}
+	}
+	for dx := 0; dx < 4; dx++ {
+		for dy := 0; dy < 4; dy++ {
+			for dz := 0; dz < 4; dz++ {
+				s := v.value(g3.Node{node[0] + dx - 1, node[1] + dy - 1, node[2] + dz - 1})
+				if s == 0 {
+					continue
+				}
+				val += w[0][dx] * w[1][dy] * w[2][dz] * s
+				grad[0] += dw[0][dx] * w[1][dy] * w[2][dz] * s
+				grad[1] += w[0][dx] * dw[1][dy] * w[2][dz] * s
+				grad[2] += w[0][dx] * w[1][dy] * dw[2][dz] * s
+			}
+		}
+	}
+	return
+}
+
+// bspline returns the weights of the uniform cubic B-spline and their derivatives
+// for the samples at -1, 0, 1 and 2 and the point t in [0, 1).
+func bspline(t float64) (w, dw [4]float64) {
+	t2, t3 := t*t, t*t*t
+	w = [4]float64{
+		(1 - t) * (1 - t) * (1 - t) / 6,
+		(3*t3 - 6*t2 + 4) / 6,
+		(-3*t3 + 3*t2 + 3*t + 1) / 6,
+		t3 / 6,
+	}
+	dw = [4]float64{
+		-(1 - t) * (1 - t) / 2,
+		(3*t2 - 4*t) / 2,
+		(-3*t2 + 2*t + 1) / 2,
+		t2 / 2,
+	}
+	return
+}
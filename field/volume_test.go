@@ -0,0 +1,156 @@
+package field
+
+import (
+	"math"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/surface"
+	"github.com/krasin/voxel/volume"
+)
+
+func TestTrilinear(t *testing.T) {
+	const n = 32
+	v := NewVoxels(box(n, [3]int{8, 8, 8}, [3]int{24, 24, 24}), 0)
+	at := func(x, y, z float64) g3.Point { return g3.Point{x / n, y / n, z / n} }
+	tests := []struct {
+		p    g3.Point
+		want float64
+	}{
+		{at(16.5, 16.5, 16.5), 1},
+		{at(4.5, 16.5, 16.5), 0},
+		{at(8, 16.5, 16.5), 0.5},
+		{at(7.75, 16.5, 16.5), 0.25},
+		{at(8, 8, 16.5), 0.25},
+		{at(8, 8, 8), 0.125},
+	}
+	for _, tt := range tests {
+		if got := v.Trilinear(tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Trilinear(%v): want %f, got %f", tt.p, tt.want, got)
+		}
+	}
+	if g := v.TrilinearGradient(at(8, 16.5, 16.5)); math.Abs(g[0]-n) > 1e-9 || g[1] != 0 || g[2] != 0 {
+		t.Errorf("TrilinearGradient at the face: want (%d, 0, 0), got %v", n, g)
+	}
+}
+
+func ballVolume(n int, c [3]float64, r float64) *volume.SparseVolume {
+	vol := volume.NewSparseVolume(n)
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			for z := 0; z < n; z++ {
+				dx, dy, dz := float64(x)+0.5-c[0], float64(y)+0.5-c[1], float64(z)+0.5-c[2]
+				if dx*dx+dy*dy+dz*dz <= r*r {
+					vol.Set16(g3.Node{x, y, z}, 1)
+				}
+			}
+		}
+	}
+	return vol
+}
+
+func TestTricubicGradient(t *testing.T) {
+	const n = 32
+	v := NewVoxels(ballVolume(n, [3]float64{16, 16, 16}, 9), 1)
+	const eps = 1e-6
+	for _, p := range []g3.Point{{0.3, 0.5, 0.5}, {0.21, 0.33, 0.47}, {0.7, 0.62, 0.35}} {
+		g := v.TricubicGradient(p)
+		for axis := range p {
+			p1, p2 := p, p
+			p1[axis] -= eps
+			p2[axis] += eps
+			want := (v.Tricubic(p2) - v.Tricubic(p1)) / (2 * eps)
+			if math.Abs(g[axis]-want) > 1e-4*(1+math.Abs(want)) {
+				t.Errorf("TricubicGradient(%v)[%d]: want %f, got %f", p, axis, want, g[axis])
+			}
+		}
+	}
+}
+
+func TestBlur(t *testing.T) {
+	const n = 32
+	v := NewVoxels(box(n, [3]int{8, 8, 8}, [3]int{24, 24, 24}), 1.5)
+	at := func(x, y, z float64) g3.Point { return g3.Point{x / n, y / n, z / n} }
+	if got := v.Trilinear(at(16.5, 16.5, 16.5)); got != 1 {
+		t.Errorf("deep inside: want 1, got %f", got)
+	}
+	if got := v.Trilinear(at(1.5, 16.5, 16.5)); got != 0 {
+		t.Errorf("far outside: want 0, got %f", got)
+	}
+	if got := v.Trilinear(at(8, 16.5, 16.5)); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("at the face: want 0.5, got %f", got)
+	}
+	prev := 0.0
+	for x := 3.5; x < 12; x++ {
+		got := v.Trilinear(at(x, 16.5, 16.5))
+		if got <= prev {
+			t.Errorf("the blurred field is not increasing at x=%f: %f after %f", x, got, prev)
+		}
+		prev = got
+	}
+}
+
+func TestTricubicSurface(t *testing.T) {
+	// The surface of the blurred ball is closer to the sphere than the voxels are.
+	const n = 32
+	const r = 10.3
+	v := NewVoxels(ballVolume(n, [3]float64{16, 16, 16}, r), 1)
+	mesh := surface.MarchingCubesMesh(v.Tricubic, 2*n, 0.5, surface.Vector{X: n, Y: n, Z: n})
+	if len(mesh.Triangle) == 0 {
+		t.Fatal("empty mesh")
+	}
+	var sum float64
+	for _, p := range mesh.Vertex {
+		d := math.Sqrt((p.X-16)*(p.X-16) + (p.Y-16)*(p.Y-16) + (p.Z-16)*(p.Z-16))
+		sum += math.Abs(d - r)
+	}
+	if avg := sum / float64(len(mesh.Vertex)); avg > 0.25 {
+		t.Errorf("the vertices are %f voxels away from the sphere on average", avg)
+	}
+}
+
+func TestBlurBand(t *testing.T) {
+	// A ball cut by the side of a volume, which is not a cube, blurred with the direct 3D kernel.
+	size := g3.Node{40, 20, 30}
+	vol := volume.NewSparseVolumeSize(size)
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				dx, dy, dz := float64(x)-12, float64(y)-4, float64(z)-15
+				if dx*dx+dy*dy+dz*dz <= 8*8 {
+					vol.Set16(g3.Node{x, y, z}, 1)
+				}
+			}
+		}
+	}
+	const sigma = 1
+	v := NewVoxels(vol, sigma)
+	r := int(math.Ceil(3 * sigma))
+	w := make([]float64, 2*r+1)
+	var sum float64
+	for i := range w {
+		w[i] = math.Exp(-float64((i-r)*(i-r)) / (2 * sigma * sigma))
+		sum += w[i]
+	}
+	for x := -r - 2; x < size[0]+r+2; x++ {
+		for y := -r - 2; y < size[1]+r+2; y++ {
+			for z := -r - 2; z < size[2]+r+2; z++ {
+				node := g3.Node{x, y, z}
+				var want float64
+				for i := range w {
+					for j := range w {
+						for k := range w {
+							if vol.Get(g3.Node{x + i - r, y + j - r, z + k - r}) {
+								want += w[i] * w[j] * w[k]
+							}
+						}
+					}
+				}
+				want /= sum * sum * sum
+				if got := v.value(node); math.Abs(got-want) > 1e-9 {
+					t.Fatalf("value(%v): want %f, got %f", node, want, got)
+				}
+			}
+		}
+	}
+}
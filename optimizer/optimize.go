@@ -9,6 +9,9 @@ import (
 	"github.com/krasin/g3"
 	"github.com/krasin/stl"
 	//	"github.com/krasin/voxel/nptl"
+	"github.com/krasin/voxel/decimate"
+	"github.com/krasin/voxel/field"
+	"github.com/krasin/voxel/poisson"
 	"github.com/krasin/voxel/raster"
 	"github.com/krasin/voxel/surface"
 	"github.com/krasin/voxel/timing"
@@ -21,64 +24,37 @@ const (
 	MeshMultiplier = 2048
 )
 
-var (
-	// For neighbours4
-	n6dx = []int{1, 0, -1, 0, 0, 0}
-	n6dy = []int{0, 1, 0, -1, 0, 0}
-	n6dz = []int{0, 0, 0, 0, 1, -1}
-
-	n4dx = n6dx[0:4]
-	n4dy = n6dy[0:4]
-)
-
-func Index(vol volume.Space16, x, y, z int) int {
-	return x*vol.N()*vol.N() + y*vol.N() + z
-}
-
-func Coord(vol volume.Space16, index int) (x, y, z int) {
-	z = index % vol.N()
-	index /= vol.N()
-	y = index % vol.N()
-	index /= vol.N()
-	x = index
-	return
-}
-
+// Optimize hollows the solid: only the voxels within n (in voxels) from the surface are kept,
+// and their values are set to the distance to the surface. The bottom (z <= 8) is not a wall,
+// so the hollow opens there.
 func Optimize(vol volume.Space16, n int) {
-	var q, q2 []int
-	vol.SetAllFilled(1, math.MaxUint16-3)
-	vol.MapBoundary(func(node g3.Node) {
-		if node[2] > 8 {
-			vol.Set16(node, 1)
-			q = append(q, Index(vol, node[0], node[1], node[2]))
-			return
-		}
-	})
-
-	for len(q) > 0 {
-		fmt.Fprintf(os.Stderr, "len(q): %d\n", len(q))
-		q, q2 = q2[:0], q
-		for _, index := range q2 {
-			x, y, z := Coord(vol, index)
-			v := vol.Get16(g3.Node{x, y, z})
-			if v == 0 {
-				panic(fmt.Sprintf("x: %d, y: %d, z: %d, v == 0", x, y, z))
-			}
-			for k := 0; k < 6; k++ {
-				x1 := x + n6dx[k]
-				y1 := y + n6dy[k]
-				z1 := z + n6dz[k]
-				v1 := vol.Get16(g3.Node{x1, y1, z1})
-				if v1 > v+1 && int(v)+1 <= n {
-					vol.Set16(g3.Node{x1, y1, z1}, v+1)
-					q = append(q, Index(vol, x1, y1, z1))
+	depth := volume.EDT(openBottom{vol}, n)
+	size := vol.Size()
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				node := g3.Node{x, y, z}
+				if !vol.Get(node) {
+					continue
+				}
+				d := depth.Get16(node)
+				if int(d) > n {
+					d = 0
 				}
+				vol.Set16(node, d)
 			}
 		}
 	}
-	vol.SetAllFilled(uint16(n+1), 0)
+	fmt.Fprintf(os.Stderr, "Compact freed %d bytes\n", vol.Compact())
+}
+
+// openBottom fills the space near the bottom of the volume, so the bottom of the solid is not a wall.
+type openBottom struct {
+	volume.Space
+}
 
-	return
+func (v openBottom) Get(node g3.Node) bool {
+	return node[2] <= 8 || v.Space.Get(node)
 }
 
 type Location16 [2]int16
@@ -131,94 +107,6 @@ func sampleField2(fX, fY, fZ float64) float64 {
 	return fResult
 }
 
-func NewVolumeField(vol volume.Space16) g3.ScalarField {
-	return func(p g3.Point) float64 {
-		for _, v := range p {
-			if v <= 0 || v >= 1 {
-				return 0
-			}
-		}
-		xx := int(p[0] * float64(vol.N()))
-		yy := int(p[1] * float64(vol.N()))
-		zz := int(p[2] * float64(vol.N()))
-		val := vol.Get(g3.Node{xx, yy, zz})
-		if val {
-			return 100
-		}
-		return 0
-	}
-}
-
-type adj struct {
-	dx, dy, dz int
-	weight     float64
-}
-
-var cells = []adj{
-	{0, 0, 0, 0.85},
-	{1, 0, 0, 0.5},
-	{0, 1, 0, 0.5},
-	{0, 0, 1, 0.5},
-	{-1, 0, 0, 0.5},
-	{0, -1, 0, 0.5},
-	{0, 0, -1, 0.5},
-	{1, 1, 0, 0.25},
-	{1, 0, 1, 0.25},
-	{0, 1, 1, 0.25},
-	{-1, 1, 0, 0.25},
-	{-1, 0, 1, 0.25},
-	{0, -1, 1, 0.25},
-	{1, -1, 0, 0.25},
-	{1, 0, -1, 0.25},
-	{0, 1, -1, 0.25},
-	{-1, -1, 0, 0.25},
-	{-1, 0, -1, 0.25},
-	{0, -1, -1, 0.25},
-}
-
-func NewVolumeField2(vol volume.Space16) g3.ScalarField {
-	return func(p g3.Point) float64 {
-		for _, v := range p {
-			if v <= 0 || v >= 1 {
-				return 0
-			}
-		}
-
-		fx := p[0] * float64(vol.N())
-		fy := p[1] * float64(vol.N())
-		fz := p[2] * float64(vol.N())
-
-		xx := int(fx)
-		yy := int(fy)
-		zz := int(fz)
-
-		dx := fx - float64(xx) - 0.5
-		dy := fy - float64(yy) - 0.5
-		dz := fz - float64(zz) - 0.5
-
-		//		r02 := dx*dx + dy*dy + dz*dz + 0.1
-
-		var val float64
-		for _, cell := range cells {
-			var v float64
-			if vol.Get(g3.Node{xx + cell.dx, yy + cell.dy, zz + cell.dz}) {
-				v = 1
-			}
-			ddx := dx - float64(cell.dx)
-			ddy := dy - float64(cell.dy)
-			ddz := dz - float64(cell.dz)
-			r2 := ddx*ddx + ddy*ddy + ddz*ddz + 0.1
-			val += cell.weight * v / r2
-		}
-		/*		v0 := float64(0)
-				if vol.Get(xx, yy, zz) {
-					v0 = 1
-				}
-				val := 0.85 * v0 / r02*/
-		return val
-	}
-}
-
 func main() {
 	timing.StartTiming("total")
 	timing.StartTiming("Read STL from Stdin")
@@ -241,7 +129,7 @@ func main() {
 	timing.StopTiming("MeshVolume")
 
 	timing.StartTiming("Rasterize")
-	vol := raster.Rasterize(mesh, VoxelSide)
+	vol := raster.RasterizeOctree(mesh, VoxelSide)
 	timing.StopTiming("Rasterize")
 
 	timing.StartTiming("Optimize")
@@ -257,9 +145,24 @@ func main() {
 		timing.StopTiming("Write nptl")
 	*/
 
-	side := mesh.Grid.Side()
-	vsize := surface.Vector{side, side, side}
-	t := surface.MarchingCubes(NewVolumeField2(vol), 128, 0.8, vsize)
+	timing.StartTiming("Poisson")
+	indicator := poisson.Reconstruct(poisson.FromVolume(vol), poisson.DefaultOptions)
+	timing.StopTiming("Poisson")
+
+	timing.StartTiming("MarchingCubes")
+	grid := surface.NewGridFrom(mesh.Grid, [3]int{128, 128, 128})
+	surf := surface.MarchingCubesGridMesh(field.World(indicator.At, mesh.Grid), grid, 0, 0)
+	timing.StopTiming("MarchingCubes")
+
+	timing.StartTiming("Smooth")
+	surf.Taubin(surface.DefaultSmoothOptions(mesh.Grid, vol.N()))
+	timing.StopTiming("Smooth")
+
+	timing.StartTiming("Decimate")
+	surf = decimate.Decimate(surf, decimate.Options{MaxError: grid.Spacing.X / 4})
+	t := surf.STL()
+	fmt.Fprintf(os.Stderr, "Triangles after decimation: %d\n", len(t))
+	timing.StopTiming("Decimate")
 	var f *os.File
 	if f, err = os.OpenFile("output.stl", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
 		log.Fatal(err)
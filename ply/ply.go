@@ -0,0 +1,443 @@
+// Package ply reads and writes meshes in the Polygon File Format.
+// See http://paulbourke.net/dataformats/ply/ for the format description.
+package ply
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/krasin/stl"
+)
+
+// Mesh is an indexed triangle mesh.
+type Mesh struct {
+	Vertex []stl.Point
+	// Normal contains per-vertex normals. It's nil, if the normals are unknown.
+	Normal []stl.Point
+	// Color contains per-vertex colors. It's nil, if the colors are unknown.
+	Color []color.RGBA
+	Face  [][3]int
+}
+
+// NewMesh converts a triangle soup into an indexed mesh.
+// Vertices with equal coordinates are merged.
+func NewMesh(t []stl.Triangle) *Mesh {
+	m := &Mesh{Face: make([][3]int, len(t))}
+	index := make(map[stl.Point]int)
+	for i, cur := range t {
+		for j, p := range cur.V {
+			ind, ok := index[p]
+			if !ok {
+				ind = len(m.Vertex)
+				index[p] = ind
+				m.Vertex = append(m.Vertex, p)
+			}
+			m.Face[i][j] = ind
+		}
+	}
+	return m
+}
+
+// Triangles converts the mesh into a triangle soup suitable for raster.STLToMesh.
+// The facet normals are computed from the vertex order.
+func (m *Mesh) Triangles() []stl.Triangle {
+	res := make([]stl.Triangle, len(m.Face))
+	for i, f := range m.Face {
+		cur := &res[i]
+		for j, ind := range f {
+			cur.V[j] = m.Vertex[ind]
+		}
+		cur.N = facetNormal(cur.V)
+	}
+	return res
+}
+
+func facetNormal(v [3]stl.Point) stl.Point {
+	var a, b [3]float64
+	for i := 0; i < 3; i++ {
+		a[i] = float64(v[1][i]) - float64(v[0][i])
+		b[i] = float64(v[2][i]) - float64(v[0][i])
+	}
+	n := [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+	l := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+	if l == 0 {
+		return stl.Point{}
+	}
+	return stl.Point{n[0] / l, n[1] / l, n[2] / l}
+}
+
+type format int
+
+const (
+	ascii format = iota
+	binaryLittleEndian
+	binaryBigEndian
+)
+
+type property struct {
+	name string
+	typ  string
+	// countTyp is the type of the list length. It's empty for scalar properties.
+	countTyp string
+}
+
+// maxPrealloc limits the number of vertices, which are allocated up front.
+// The count in the header is not trusted beyond it, so a corrupted header
+// fails on the missing data instead of exhausting the memory.
+const maxPrealloc = 1 << 20
+
+type element struct {
+	name  string
+	count int
+	props []property
+}
+
+var typeSize = map[string]int{
+	"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+	"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+	"int": 4, "int32": 4, "uint": 4, "uint32": 4,
+	"float": 4, "float32": 4, "double": 8, "float64": 8,
+}
+
+func readHeader(r *bufio.Reader) (f format, elems []*element, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(line) != "ply" {
+		return f, nil, fmt.Errorf("ply: bad magic: %q", line)
+	}
+	hasFormat := false
+	for {
+		if line, err = r.ReadString('\n'); err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "comment", "obj_info":
+		case "format":
+			if len(fields) != 3 {
+				return f, nil, fmt.Errorf("ply: bad format line: %q", line)
+			}
+			switch fields[1] {
+			case "ascii":
+				f = ascii
+			case "binary_little_endian":
+				f = binaryLittleEndian
+			case "binary_big_endian":
+				f = binaryBigEndian
+			default:
+				return f, nil, fmt.Errorf("ply: unknown format: %q", fields[1])
+			}
+			hasFormat = true
+		case "element":
+			if len(fields) != 3 {
+				return f, nil, fmt.Errorf("ply: bad element line: %q", line)
+			}
+			var count int
+			if count, err = strconv.Atoi(fields[2]); err != nil || count < 0 {
+				return f, nil, fmt.Errorf("ply: bad element count: %q", line)
+			}
+			elems = append(elems, &element{name: fields[1], count: count})
+		case "property":
+			if len(elems) == 0 {
+				return f, nil, fmt.Errorf("ply: property before element: %q", line)
+			}
+			var p property
+			switch {
+			case len(fields) == 3:
+				p = property{name: fields[2], typ: fields[1]}
+			case len(fields) == 5 && fields[1] == "list":
+				p = property{name: fields[4], typ: fields[3], countTyp: fields[2]}
+				if typeSize[p.countTyp] == 0 {
+					return f, nil, fmt.Errorf("ply: unknown type: %q", line)
+				}
+			default:
+				return f, nil, fmt.Errorf("ply: bad property line: %q", line)
+			}
+			if typeSize[p.typ] == 0 {
+				return f, nil, fmt.Errorf("ply: unknown type: %q", line)
+			}
+			cur := elems[len(elems)-1]
+			cur.props = append(cur.props, p)
+		case "end_header":
+			if !hasFormat {
+				return f, nil, fmt.Errorf("ply: no format line in the header")
+			}
+			return
+		default:
+			return f, nil, fmt.Errorf("ply: unknown header line: %q", line)
+		}
+	}
+}
+
+// valueReader reads the body of a PLY file value by value.
+type valueReader interface {
+	read(typ string) (float64, error)
+}
+
+type asciiReader struct {
+	s *bufio.Scanner
+}
+
+func (r *asciiReader) read(typ string) (float64, error) {
+	if !r.s.Scan() {
+		if err := r.s.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.ErrUnexpectedEOF
+	}
+	return strconv.ParseFloat(r.s.Text(), 64)
+}
+
+type binaryReader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	buf   [8]byte
+}
+
+func (r *binaryReader) read(typ string) (float64, error) {
+	b := r.buf[:typeSize[typ]]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	switch typ {
+	case "char", "int8":
+		return float64(int8(b[0])), nil
+	case "uchar", "uint8":
+		return float64(b[0]), nil
+	case "short", "int16":
+		return float64(int16(r.order.Uint16(b))), nil
+	case "ushort", "uint16":
+		return float64(r.order.Uint16(b)), nil
+	case "int", "int32":
+		return float64(int32(r.order.Uint32(b))), nil
+	case "uint", "uint32":
+		return float64(r.order.Uint32(b)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(r.order.Uint32(b))), nil
+	}
+	return math.Float64frombits(r.order.Uint64(b)), nil
+}
+
+// colorValue converts a color component to a byte. Integer components
+// are used as is, while floating point ones are expected to be in [0, 1].
+func colorValue(typ string, v float64) uint8 {
+	if typ == "float" || typ == "float32" || typ == "double" || typ == "float64" {
+		v *= 255
+	}
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// Read parses a PLY file. Polygonal faces are triangulated as fans.
+// Unknown elements and properties are skipped.
+func Read(r io.Reader) (m *Mesh, err error) {
+	br := bufio.NewReader(r)
+	f, elems, err := readHeader(br)
+	if err != nil {
+		return
+	}
+	var vr valueReader
+	switch f {
+	case ascii:
+		s := bufio.NewScanner(br)
+		s.Split(bufio.ScanWords)
+		vr = &asciiReader{s: s}
+	case binaryLittleEndian:
+		vr = &binaryReader{r: br, order: binary.LittleEndian}
+	case binaryBigEndian:
+		vr = &binaryReader{r: br, order: binary.BigEndian}
+	}
+
+	m = new(Mesh)
+	var vals []float64
+	for _, e := range elems {
+		hasNormal, hasColor := false, false
+		if e.name == "vertex" {
+			prealloc := e.count
+			if prealloc > maxPrealloc {
+				prealloc = maxPrealloc
+			}
+			m.Vertex = make([]stl.Point, 0, prealloc)
+			for _, p := range e.props {
+				switch p.name {
+				case "nx", "ny", "nz":
+					hasNormal = true
+				case "red", "green", "blue", "r", "g", "b", "diffuse_red", "diffuse_green", "diffuse_blue":
+					hasColor = true
+				}
+			}
+			if hasNormal {
+				m.Normal = make([]stl.Point, 0, prealloc)
+			}
+			if hasColor {
+				m.Color = make([]color.RGBA, 0, prealloc)
+			}
+		}
+		for i := 0; i < e.count; i++ {
+			var p, n stl.Point
+			c := color.RGBA{A: 255}
+			for _, prop := range e.props {
+				if prop.countTyp != "" {
+					var cnt float64
+					if cnt, err = vr.read(prop.countTyp); err != nil {
+						return nil, err
+					}
+					if cnt < 0 || cnt != math.Floor(cnt) {
+						return nil, fmt.Errorf("ply: bad list length: %v", cnt)
+					}
+					vals = vals[:0]
+					for j := 0; j < int(cnt); j++ {
+						var v float64
+						if v, err = vr.read(prop.typ); err != nil {
+							return nil, err
+						}
+						vals = append(vals, v)
+					}
+					if e.name == "face" && (prop.name == "vertex_indices" || prop.name == "vertex_index") {
+						if err = m.addFace(vals); err != nil {
+							return nil, err
+						}
+					}
+					continue
+				}
+				var v float64
+				if v, err = vr.read(prop.typ); err != nil {
+					return nil, err
+				}
+				if e.name != "vertex" {
+					continue
+				}
+				switch prop.name {
+				case "x":
+					p[0] = v
+				case "y":
+					p[1] = v
+				case "z":
+					p[2] = v
+				case "nx":
+					n[0] = v
+				case "ny":
+					n[1] = v
+				case "nz":
+					n[2] = v
+				case "red", "r", "diffuse_red":
+					c.R = colorValue(prop.typ, v)
+				case "green", "g", "diffuse_green":
+					c.G = colorValue(prop.typ, v)
+				case "blue", "b", "diffuse_blue":
+					c.B = colorValue(prop.typ, v)
+				case "alpha", "a":
+					c.A = colorValue(prop.typ, v)
+				}
+			}
+			if e.name == "vertex" {
+				m.Vertex = append(m.Vertex, p)
+				if hasNormal {
+					m.Normal = append(m.Normal, n)
+				}
+				if hasColor {
+					m.Color = append(m.Color, c)
+				}
+			}
+		}
+	}
+	for _, f := range m.Face {
+		for _, ind := range f {
+			if ind >= len(m.Vertex) {
+				return nil, fmt.Errorf("ply: vertex index %d out of range [0, %d)", ind, len(m.Vertex))
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *Mesh) addFace(ind []float64) error {
+	if len(ind) < 3 {
+		// Degenerate faces are skipped.
+		return nil
+	}
+	for _, v := range ind {
+		if v < 0 || v != math.Floor(v) {
+			return fmt.Errorf("ply: bad vertex index: %v", v)
+		}
+	}
+	for i := 2; i < len(ind); i++ {
+		m.Face = append(m.Face, [3]int{int(ind[0]), int(ind[i-1]), int(ind[i])})
+	}
+	return nil
+}
+
+// Write writes the mesh as a binary little endian PLY file.
+// Normals and colors are written, if present.
+func Write(w io.Writer, m *Mesh) (err error) {
+	if m.Normal != nil && len(m.Normal) != len(m.Vertex) {
+		return fmt.Errorf("ply: len(Normal) = %d, want %d", len(m.Normal), len(m.Vertex))
+	}
+	if m.Color != nil && len(m.Color) != len(m.Vertex) {
+		return fmt.Errorf("ply: len(Color) = %d, want %d", len(m.Color), len(m.Vertex))
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "ply\nformat binary_little_endian 1.0\n")
+	fmt.Fprintf(bw, "element vertex %d\n", len(m.Vertex))
+	fmt.Fprintf(bw, "property float x\nproperty float y\nproperty float z\n")
+	if m.Normal != nil {
+		fmt.Fprintf(bw, "property float nx\nproperty float ny\nproperty float nz\n")
+	}
+	if m.Color != nil {
+		fmt.Fprintf(bw, "property uchar red\nproperty uchar green\nproperty uchar blue\nproperty uchar alpha\n")
+	}
+	fmt.Fprintf(bw, "element face %d\n", len(m.Face))
+	fmt.Fprintf(bw, "property list uchar int vertex_indices\n")
+	fmt.Fprintf(bw, "end_header\n")
+
+	var buf [4]byte
+	writeFloat := func(v float64) {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(v)))
+		bw.Write(buf[:])
+	}
+	for i, p := range m.Vertex {
+		for _, v := range p {
+			writeFloat(float64(v))
+		}
+		if m.Normal != nil {
+			for _, v := range m.Normal[i] {
+				writeFloat(float64(v))
+			}
+		}
+		if m.Color != nil {
+			c := m.Color[i]
+			bw.Write([]byte{c.R, c.G, c.B, c.A})
+		}
+	}
+	for _, f := range m.Face {
+		bw.WriteByte(3)
+		for _, ind := range f {
+			binary.LittleEndian.PutUint32(buf[:], uint32(ind))
+			bw.Write(buf[:])
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,135 @@
+package ply
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/krasin/stl"
+)
+
+const asciiCube = `ply
+format ascii 1.0
+comment a unit square with an extra property
+element vertex 4
+property float x
+property float y
+property float z
+property float confidence
+property float nx
+property float ny
+property float nz
+property uchar red
+property uchar green
+property uchar blue
+element face 1
+property list uchar int vertex_indices
+property uchar flags
+element material 1
+property list uchar float values
+end_header
+0 0 0 0.5 0 0 1 255 0 0
+1 0 0 0.5 0 0 1 0 255 0
+1 1 0 0.5 0 0 1 0 0 255
+0 1 0 0.5 0 0 1 10 20 30
+4 0 1 2 3 7
+2 1.5 2.5
+`
+
+func TestReadASCII(t *testing.T) {
+	m, err := Read(strings.NewReader(asciiCube))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	wantVertex := []stl.Point{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}
+	if !reflect.DeepEqual(m.Vertex, wantVertex) {
+		t.Errorf("Vertex: want %v, got %v", wantVertex, m.Vertex)
+	}
+	wantFace := [][3]int{{0, 1, 2}, {0, 2, 3}}
+	if !reflect.DeepEqual(m.Face, wantFace) {
+		t.Errorf("Face: want %v, got %v", wantFace, m.Face)
+	}
+	if len(m.Normal) != 4 || m.Normal[3] != (stl.Point{0, 0, 1}) {
+		t.Errorf("Normal: got %v", m.Normal)
+	}
+	if len(m.Color) != 4 || m.Color[3] != (color.RGBA{10, 20, 30, 255}) {
+		t.Errorf("Color: got %v", m.Color)
+	}
+	for i, tr := range m.Triangles() {
+		if tr.N != (stl.Point{0, 0, 1}) {
+			t.Errorf("Triangles()[%d].N: want (0, 0, 1), got %v", i, tr.N)
+		}
+	}
+}
+
+func TestReadHugeCount(t *testing.T) {
+	// The header claims far more vertices than there are, which must fail on the missing data.
+	const header = "ply\nformat binary_little_endian 1.0\nelement vertex 4000000000000000000\n" +
+		"property float x\nproperty float y\nproperty float z\nproperty float nx\nend_header\n"
+	if _, err := Read(strings.NewReader(header + "\x00\x00\x80\x3f")); err == nil {
+		t.Error("Read: want an error for the truncated vertices")
+	}
+}
+
+func TestReadBigEndian(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("ply\nformat binary_big_endian 1.0\nelement vertex 3\n" +
+		"property double x\nproperty double y\nproperty double z\n" +
+		"element face 1\nproperty list uchar uint vertex_index\nend_header\n")
+	for _, v := range []float64{0, 0, 0, 2, 0, 0, 0, 3, 0} {
+		binary.Write(&buf, binary.BigEndian, math.Float64bits(v))
+	}
+	buf.WriteByte(3)
+	binary.Write(&buf, binary.BigEndian, []uint32{2, 1, 0})
+
+	m, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if want := []stl.Point{{0, 0, 0}, {2, 0, 0}, {0, 3, 0}}; !reflect.DeepEqual(m.Vertex, want) {
+		t.Errorf("Vertex: want %v, got %v", want, m.Vertex)
+	}
+	if want := [][3]int{{2, 1, 0}}; !reflect.DeepEqual(m.Face, want) {
+		t.Errorf("Face: want %v, got %v", want, m.Face)
+	}
+}
+
+func TestDevilRoundTrip(t *testing.T) {
+	f, err := os.Open("../src/data/devil.ply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := Read(f)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(m.Vertex) != 37089 || len(m.Face) != 74014 {
+		t.Fatalf("Read: want 37089 vertices and 74014 faces, got %d and %d", len(m.Vertex), len(m.Face))
+	}
+
+	var buf bytes.Buffer
+	if err = Write(&buf, m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read after Write: %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Read after Write: the mesh has changed")
+	}
+
+	// Convert to a triangle soup and back. The number of vertices may only decrease,
+	// as the vertices with equal coordinates are merged.
+	m2 := NewMesh(m.Triangles())
+	if len(m2.Face) != len(m.Face) || len(m2.Vertex) > len(m.Vertex) {
+		t.Errorf("NewMesh: want %d faces and at most %d vertices, got %d and %d",
+			len(m.Face), len(m.Vertex), len(m2.Face), len(m2.Vertex))
+	}
+}
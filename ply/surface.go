@@ -0,0 +1,25 @@
+package ply
+
+import (
+	"github.com/krasin/stl"
+	"github.com/krasin/voxel/surface"
+)
+
+// FromSurface converts an indexed mesh produced by the surface package.
+func FromSurface(sm *surface.Mesh) *Mesh {
+	m := &Mesh{
+		Vertex: make([]stl.Point, len(sm.Vertex)),
+		Face:   make([][3]int, len(sm.Triangle)),
+	}
+	for i, v := range sm.Vertex {
+		m.Vertex[i] = stl.Point{v.X, v.Y, v.Z}
+	}
+	if len(sm.Normal) == len(sm.Vertex) {
+		m.Normal = make([]stl.Point, len(sm.Normal))
+		for i, v := range sm.Normal {
+			m.Normal[i] = stl.Point{v.X, v.Y, v.Z}
+		}
+	}
+	copy(m.Face, sm.Triangle)
+	return m
+}
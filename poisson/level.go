@@ -0,0 +1,242 @@
+package poisson
+
+import "math"
+
+// level is a level of the octree: a grid over the domain, which only has the nodes
+// of the active cells. The coarsest level has all cells active.
+type level struct {
+	// n is the number of cells along each axis.
+	n int
+	h float64
+	// active contains the keys of the active cells. It's nil for the coarsest level.
+	active map[int]bool
+	// index maps the key of the node to its index in nodes and val.
+	index map[int]int
+	nodes [][3]int
+	val   []float64
+}
+
+func newLevel(depth int, pts [][3]float64, f *Function) *level {
+	n := 1 << uint(depth)
+	l := &level{
+		n:     n,
+		h:     1 / float64(n),
+		index: make(map[int]int),
+	}
+	if len(f.levels) == 0 {
+		for x := 0; x <= n; x++ {
+			for y := 0; y <= n; y++ {
+				for z := 0; z <= n; z++ {
+					l.addNode([3]int{x, y, z}, outside)
+				}
+			}
+		}
+		return l
+	}
+
+	// Refine the cells with the points and their neighbours. The values at the new nodes
+	// are taken from the coarser levels.
+	l.active = make(map[int]bool)
+	for _, p := range pts {
+		c := l.cell(p)
+		for x := c[0] - band; x <= c[0]+band; x++ {
+			for y := c[1] - band; y <= c[1]+band; y++ {
+				for z := c[2] - band; z <= c[2]+band; z++ {
+					if x < 0 || x >= n || y < 0 || y >= n || z < 0 || z >= n || l.active[l.cellKey(x, y, z)] {
+						continue
+					}
+					l.active[l.cellKey(x, y, z)] = true
+					for _, o := range cornerOffset {
+						node := [3]int{x + o[0], y + o[1], z + o[2]}
+						if _, ok := l.index[l.nodeKey(node)]; !ok {
+							l.addNode(node, f.value(l.pos(node)))
+						}
+					}
+				}
+			}
+		}
+	}
+	return l
+}
+
+var cornerOffset = [8][3]int{
+	{0, 0, 0}, {0, 0, 1}, {0, 1, 0}, {0, 1, 1},
+	{1, 0, 0}, {1, 0, 1}, {1, 1, 0}, {1, 1, 1},
+}
+
+func (l *level) addNode(node [3]int, v float64) {
+	l.index[l.nodeKey(node)] = len(l.nodes)
+	l.nodes = append(l.nodes, node)
+	l.val = append(l.val, v)
+}
+
+func (l *level) cellKey(x, y, z int) int {
+	return (x*l.n+y)*l.n + z
+}
+
+func (l *level) nodeKey(node [3]int) int {
+	return (node[0]*(l.n+1)+node[1])*(l.n+1) + node[2]
+}
+
+func (l *level) pos(node [3]int) [3]float64 {
+	return [3]float64{float64(node[0]) * l.h, float64(node[1]) * l.h, float64(node[2]) * l.h}
+}
+
+// cell returns the cell, which contains the point of the domain.
+func (l *level) cell(u [3]float64) (c [3]int) {
+	for i, v := range u {
+		c[i] = int(v * float64(l.n))
+		if c[i] >= l.n {
+			c[i] = l.n - 1
+		}
+	}
+	return
+}
+
+// corners returns the indices of the corners of the cell, which contains u,
+// and their trilinear weights.
+func (l *level) corners(u [3]float64) (ind [8]int, w [8]float64) {
+	c := l.cell(u)
+	var t [3]float64
+	for i := range t {
+		t[i] = u[i]*float64(l.n) - float64(c[i])
+	}
+	for i, o := range cornerOffset {
+		ind[i] = l.index[l.nodeKey([3]int{c[0] + o[0], c[1] + o[1], c[2] + o[2]})]
+		w[i] = 1
+		for axis := range o {
+			if o[axis] == 0 {
+				w[i] *= 1 - t[axis]
+			} else {
+				w[i] *= t[axis]
+			}
+		}
+	}
+	return
+}
+
+// value returns the value at the point of the domain, if the level covers it.
+func (l *level) value(u [3]float64) (float64, bool) {
+	if l.active != nil {
+		c := l.cell(u)
+		if !l.active[l.cellKey(c[0], c[1], c[2])] {
+			return 0, false
+		}
+	}
+	ind, w := l.corners(u)
+	var res float64
+	for i := range ind {
+		res += w[i] * l.val[ind[i]]
+	}
+	return res, true
+}
+
+// solve solves the screened Poisson equation -Δχ + αSχ = -∇·V for the nodes of the level,
+// which have all six neighbours. V is the vector field made of the inward normals of the samples,
+// and S is the density of the area of the samples. The other nodes keep their values.
+// The equation is multiplied by h², so the matrix has 6 + h²αS on the diagonal.
+func (l *level) solve(samples []Sample, pts [][3]float64, alpha float64, iters int) {
+	vec := make([][3]float64, len(l.nodes))
+	density := make([]float64, len(l.nodes))
+	h3 := l.h * l.h * l.h
+	for i, s := range samples {
+		area := math.Sqrt(s.N[0]*s.N[0] + s.N[1]*s.N[1] + s.N[2]*s.N[2])
+		ind, w := l.corners(pts[i])
+		for j := range ind {
+			for axis := range vec[ind[j]] {
+				vec[ind[j]][axis] -= w[j] * s.N[axis] / h3
+			}
+			density[ind[j]] += w[j] * area / h3
+		}
+	}
+
+	// unknown maps the node to its index in x, or -1 if its value is fixed.
+	unknown := make([]int, len(l.nodes))
+	var nodes []int
+	var nbs [][6]int
+	for i, node := range l.nodes {
+		unknown[i] = -1
+		var nb [6]int
+		ok := true
+		for k := 0; k < 6 && ok; k++ {
+			cur := node
+			cur[k/2] += 2*(k%2) - 1
+			nb[k], ok = l.index[l.nodeKey(cur)]
+			ok = ok && cur[k/2] >= 0 && cur[k/2] <= l.n
+		}
+		if !ok {
+			continue
+		}
+		unknown[i] = len(nodes)
+		nodes = append(nodes, i)
+		nbs = append(nbs, nb)
+	}
+
+	x := make([]float64, len(nodes))
+	b := make([]float64, len(nodes))
+	diag := make([]float64, len(nodes))
+	for i, node := range nodes {
+		x[i] = l.val[node]
+		diag[i] = 6 + l.h*l.h*alpha*density[node]
+		var div float64
+		for axis := 0; axis < 3; axis++ {
+			div += (vec[nbs[i][2*axis+1]][axis] - vec[nbs[i][2*axis]][axis]) / (2 * l.h)
+		}
+		b[i] = -l.h * l.h * div
+		for _, nb := range nbs[i] {
+			if unknown[nb] < 0 {
+				b[i] += l.val[nb]
+			}
+		}
+	}
+	mul := func(v, res []float64) {
+		for i := range v {
+			s := diag[i] * v[i]
+			for _, nb := range nbs[i] {
+				if j := unknown[nb]; j >= 0 {
+					s -= v[j]
+				}
+			}
+			res[i] = s
+		}
+	}
+	conjugateGradients(mul, b, x, iters)
+	for i, node := range nodes {
+		l.val[node] = x[i]
+	}
+}
+
+// conjugateGradients improves the solution x of the symmetric positive definite system Ax = b,
+// where mul computes Av. It stops after iters iterations, or when the residual is small enough.
+func conjugateGradients(mul func(v, res []float64), b, x []float64, iters int) {
+	r := make([]float64, len(x))
+	p := make([]float64, len(x))
+	ap := make([]float64, len(x))
+	mul(x, ap)
+	var rr, bb float64
+	for i := range r {
+		r[i] = b[i] - ap[i]
+		p[i] = r[i]
+		rr += r[i] * r[i]
+		bb += b[i] * b[i]
+	}
+	for it := 0; it < iters && rr > 1e-20*bb && rr > 0; it++ {
+		mul(p, ap)
+		var pap float64
+		for i := range p {
+			pap += p[i] * ap[i]
+		}
+		a := rr / pap
+		var rr2 float64
+		for i := range x {
+			x[i] += a * p[i]
+			r[i] -= a * ap[i]
+			rr2 += r[i] * r[i]
+		}
+		beta := rr2 / rr
+		rr = rr2
+		for i := range p {
+			p[i] = r[i] + beta*p[i]
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package poisson implements the screened Poisson surface reconstruction,
+// see M. Kazhdan, H. Hoppe, "Screened Poisson Surface Reconstruction".
+//
+// The indicator function of the solid is found from the oriented points on its surface:
+// its gradient must match the inward normals, and its value at the points must be close
+// to the value at the surface. The equation is discretized with finite differences on the
+// nested grids of an octree. The coarsest grid covers the whole domain, and the finer grids
+// only cover the cells near the points. The levels are solved from the coarse to the fine
+// ones with conjugate gradients (a cascadic multigrid): the solution of the coarser level
+// is the initial guess and the boundary condition for the finer one.
+//
+// The points and the resulting function are defined over the unit cube like the fields
+// of surface.MarchingCubes. The function is positive inside of the solid,
+// so the surface is extracted with the threshold 0.
+package poisson
+
+import (
+	"math"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/volume"
+)
+
+// Sample is an oriented point on the surface.
+type Sample struct {
+	P g3.Point
+	// N is the outward normal scaled by the area of the surface around the point.
+	N g3.Vector
+}
+
+// Options control the reconstruction.
+type Options struct {
+	// Depth is the depth of the finest level of the octree.
+	// Its grid has 2^Depth cells along each axis of the domain.
+	Depth int
+	// Screening is the weight of the values at the points (α in the paper).
+	// Zero means the plain Poisson reconstruction.
+	Screening float64
+	// Iterations is the number of conjugate gradient iterations on the finer levels.
+	// The coarsest level is solved with four times as many.
+	Iterations int
+}
+
+// DefaultOptions are the options used in the paper.
+var DefaultOptions = Options{Depth: 7, Screening: 4, Iterations: 50}
+
+const (
+	// The domain is the unit cube with the margin of pad on each side,
+	// so the surface is far enough from the boundary condition.
+	pad = 0.25
+	// coarseDepth is the depth of the coarsest level.
+	coarseDepth = 4
+	// band is the number of cells around the cells with the points, which are refined.
+	band = 2
+	// outside is the value of the indicator function outside of the solid.
+	// It's 0.5 inside of the solid, so it's 0 at the surface.
+	outside = -0.5
+)
+
+// FromVolume returns the boundary voxels of the solid with their normals, see volume.Normal.
+// A plane with the unit normal N crosses n²·|N|∞ boundary voxels per unit of area,
+// where |N|∞ is the largest of the absolute values of the components of N, so every boundary voxel
+// stands for the area 1/(n²·|N|∞). The surface of the voxels runs on average |N|∞/2 voxels
+// outside of their centers, so the points are moved by that much along the normal.
+func FromVolume(vol volume.Space16) []Sample {
+	var res []Sample
+	n := float64(vol.N())
+	vol.MapBoundary(func(node g3.Node) {
+		nv := volume.Normal(vol, node)
+		m := math.Max(math.Abs(nv[0]), math.Max(math.Abs(nv[1]), math.Abs(nv[2])))
+		area := 1 / (n * n * m)
+		var s Sample
+		for i := range s.P {
+			s.P[i] = (float64(node[i]) + 0.5 + nv[i]*m/2) / n
+			s.N[i] = nv[i] * area
+		}
+		res = append(res, s)
+	})
+	return res
+}
+
+// Function is the reconstructed indicator function.
+type Function struct {
+	levels []*level
+	// iso is the average value at the points, which is subtracted from the indicator function.
+	iso float64
+}
+
+// Reconstruct finds the indicator function of the solid with the given oriented points on its surface.
+func Reconstruct(samples []Sample, opt Options) *Function {
+	if opt.Depth < coarseDepth {
+		opt.Depth = coarseDepth
+	}
+	if opt.Iterations <= 0 {
+		opt.Iterations = DefaultOptions.Iterations
+	}
+	pts := make([][3]float64, len(samples))
+	for i, s := range samples {
+		pts[i] = toDomain(s.P)
+	}
+	f := new(Function)
+	for d := coarseDepth; d <= opt.Depth; d++ {
+		l := newLevel(d, pts, f)
+		iters := opt.Iterations
+		if d == coarseDepth {
+			iters *= 4
+		}
+		l.solve(samples, pts, opt.Screening, iters)
+		f.levels = append(f.levels, l)
+	}
+	if len(pts) > 0 {
+		var sum float64
+		for _, p := range pts {
+			sum += f.value(p)
+		}
+		f.iso = sum / float64(len(pts))
+	}
+	return f
+}
+
+// At returns the value of the function at p. It's positive inside of the solid.
+func (f *Function) At(p g3.Point) float64 {
+	return f.value(toDomain(p)) - f.iso
+}
+
+// value returns the value of the indicator function at the point of the domain,
+// taken from the finest level, which covers the point.
+func (f *Function) value(u [3]float64) float64 {
+	for i := len(f.levels) - 1; i >= 0; i-- {
+		if v, ok := f.levels[i].value(u); ok {
+			return v
+		}
+	}
+	return outside
+}
+
+func toDomain(p g3.Point) (u [3]float64) {
+	for i, v := range p {
+		v = (v + pad) / (1 + 2*pad)
+		if v < 0 {
+			v = 0
+		}
+		if v > 1 {
+			v = 1
+		}
+		u[i] = v
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package poisson
+
+import (
+	"math"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/surface"
+	"github.com/krasin/voxel/volume"
+)
+
+func sphere(n int, c [3]float64, r float64) *volume.SparseVolume {
+	vol := volume.NewSparseVolume(n)
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			for z := 0; z < n; z++ {
+				dx, dy, dz := float64(x)+0.5-c[0], float64(y)+0.5-c[1], float64(z)+0.5-c[2]
+				if dx*dx+dy*dy+dz*dz <= r*r {
+					vol.Set16(g3.Node{x, y, z}, 1)
+				}
+			}
+		}
+	}
+	return vol
+}
+
+func TestFromVolume(t *testing.T) {
+	const n = 64
+	vol := sphere(n, [3]float64{32, 32, 32}, 20)
+	samples := FromVolume(vol)
+	if len(samples) == 0 {
+		t.Fatal("no samples")
+	}
+	var area float64
+	for _, s := range samples {
+		d := g3.Vector{s.P[0] - 0.5, s.P[1] - 0.5, s.P[2] - 0.5}
+		if d[0]*s.N[0]+d[1]*s.N[1]+d[2]*s.N[2] <= 0 {
+			t.Fatalf("the normal %v at %v points inwards", s.N, s.P)
+		}
+		area += math.Sqrt(s.N[0]*s.N[0] + s.N[1]*s.N[1] + s.N[2]*s.N[2])
+	}
+	want := 4 * math.Pi * (20. / n) * (20. / n)
+	if math.Abs(area-want) > 0.1*want {
+		t.Errorf("the total area: want %f, got %f", want, area)
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	const n = 64
+	const r = 20
+	vol := sphere(n, [3]float64{32, 32, 32}, r)
+	f := Reconstruct(FromVolume(vol), Options{Depth: 6, Screening: 4, Iterations: 50})
+
+	if v := f.At(g3.Point{0.5, 0.5, 0.5}); v <= 0 {
+		t.Errorf("the center: want a positive value, got %f", v)
+	}
+	for _, p := range []g3.Point{{0.05, 0.5, 0.5}, {0.5, 0.95, 0.5}, {0.1, 0.1, 0.1}} {
+		if v := f.At(p); v >= 0 {
+			t.Errorf("At(%v): want a negative value outside, got %f", p, v)
+		}
+	}
+
+	mesh := surface.MarchingCubesMesh(f.At, n, 0, surface.Vector{n, n, n})
+	if len(mesh.Triangle) == 0 {
+		t.Fatal("empty mesh")
+	}
+	var vol6 float64
+	for _, tr := range mesh.Triangle {
+		a, b, c := mesh.Vertex[tr[0]], mesh.Vertex[tr[1]], mesh.Vertex[tr[2]]
+		vol6 += a.X*(b.Y*c.Z-b.Z*c.Y) + a.Y*(b.Z*c.X-b.X*c.Z) + a.Z*(b.X*c.Y-b.Y*c.X)
+	}
+	want := 4. / 3 * math.Pi * r * r * r
+	if got := vol6 / 6; math.Abs(got-want) > 0.05*want {
+		t.Errorf("the volume of the surface: want %f, got %f", want, got)
+	}
+	for _, v := range mesh.Vertex {
+		d := math.Sqrt((v.X-32)*(v.X-32) + (v.Y-32)*(v.Y-32) + (v.Z-32)*(v.Z-32))
+		if math.Abs(d-r) > 1.5 {
+			t.Errorf("vertex %v is %f away from the center, want about %d", v, d, r)
+			break
+		}
+	}
+}
@@ -103,9 +103,16 @@ func STLToMesh(n int, triangles []stl.Triangle) (m Mesh) {
 	return
 }
 
+// interiorColor is the color of the interior of the solid in RasterizeOctree.
+// The colors of the triangles are below it.
+const interiorColor = 11
+
+// Rasterize returns the solid bounded by the mesh. The volume is cut to the bounding box of the mesh
+// with one empty voxel after it along each axis, so the long and thin models don't waste memory.
 func Rasterize(m Mesh, n int) volume.Space16 {
 	scale := m.N / n
-	vol := volume.NewSparseVolume(n)
+	size := meshSize(m, n)
+	vol := volume.NewSparseVolumeSize(size)
 
 	timing.StartTiming("Rasterize triangles")
 	for index, t := range m.Triangle {
@@ -127,11 +134,12 @@ func Rasterize(m Mesh, n int) volume.Space16 {
 		if cube != nil {
 			continue
 		}
-		p := volume.K2cube(k)
+		p := vol.K2cube(k)
 
-		// If this is a cube at the edge of the space, it's a part of outer space.
-		if p[0] == 0 || p[1] == 0 || p[2] == 0 ||
-			int(p[0]) == (1<<uint(vol.LK))-1 || int(p[1]) == (1<<uint(vol.LK))-1 || int(p[2]) == (1<<uint(vol.LK))-1 {
+		// If this is a cube at the edge of the space or beyond it, it's a part of outer space.
+		last := vol.CubeCount()
+		if !vol.CubeInside(p) || p[0] == 0 || p[1] == 0 || p[2] == 0 ||
+			p[0] == last[0]-1 || p[1] == last[1]-1 || p[2] == last[2]-1 {
 			vol.Colors[k] = uint16(shift + ds.Find(0))
 			continue
 		}
@@ -141,7 +149,7 @@ func Rasterize(m Mesh, n int) volume.Space16 {
 			for j := -1; j <= 1; j += 2 {
 				p2 := p
 				p2[i] = p2[i] + j
-				k2 := volume.Cube2k(p2)
+				k2 := vol.Cube2k(p2)
 				if k2 >= len(vol.Colors) {
 					panic(fmt.Sprintf("k2: %d, len(vol.Colors): %d, len(vol.Cubes): %d, p: %v, p2: %v, k: %d", k2, len(vol.Colors), len(vol.Cubes), p, p2, k))
 				}
@@ -173,7 +181,11 @@ func Rasterize(m Mesh, n int) volume.Space16 {
 			if val != 0 {
 				continue
 			}
-			p := volume.Kh2point(k, h)
+			p := vol.Kh2point(k, h)
+			if p[0] >= size[0] || p[1] >= size[1] || p[2] >= size[2] {
+				// The voxel of a partial cube, which is outside of the volume.
+				continue
+			}
 			color := val
 			// Look for neighbours of this leaf voxel
 			for i := 0; i < 3; i++ {
@@ -181,6 +193,10 @@ func Rasterize(m Mesh, n int) volume.Space16 {
 					p2 := p
 					p2[i] = p2[i] + j
 					color2 := vol.Get16(g3.Node{int(p2[0]), int(p2[1]), int(p2[2])})
+					if p2[i] < 0 || p2[i] >= size[i] {
+						// The voxel at the boundary of the volume touches the outer space.
+						color2 = uint16(shift + ds.Find(0))
+					}
 					if int(color2) < shift {
 						continue
 					}
@@ -222,6 +238,62 @@ func Rasterize(m Mesh, n int) volume.Space16 {
 	}
 	timing.StopTiming("Rasterize.CanonicalizeColors")
 
+	timing.StartTiming("Rasterize.Compact")
+	fmt.Fprintf(os.Stderr, "Compact freed %d bytes\n", vol.Compact())
+	timing.StopTiming("Rasterize.Compact")
+
+	drawSlices(vol, n)
+	fmt.Fprintf(os.Stderr, "Rasterize complete\n")
+	return vol
+}
+
+// RasterizeOctree is like Rasterize, but the solid is stored in volume.Octree, which allocates nothing
+// up front, so it suits the large n. The interior is found by flooding the empty space from the outside,
+// and it has the color 11.
+func RasterizeOctree(m Mesh, n int) volume.Space16 {
+	scale := m.N / n
+	vol := volume.NewOctreeSize(meshSize(m, n))
+
+	timing.StartTiming("Rasterize triangles")
+	for index, t := range m.Triangle {
+		triangle.AllTriangleDots(t[0], t[1], t[2], int64(scale), vol, uint16(1+(index%10)))
+	}
+	fmt.Fprintf(os.Stderr, "Triangle rasterization complete\n")
+	timing.StopTiming("Rasterize triangles")
+
+	timing.StartTiming("Rasterize.FillInterior")
+	vol.FillInterior(interiorColor)
+	timing.StopTiming("Rasterize.FillInterior")
+
+	drawSlices(vol, n)
+	fmt.Fprintf(os.Stderr, "Rasterize complete\n")
+	return vol
+}
+
+// meshSize returns the size of the volume, which holds the mesh rasterized into the cube of side n:
+// the bounding box of the mesh with one empty voxel after it along each axis.
+func meshSize(m Mesh, n int) g3.Node {
+	scale := m.N / n
+	size := g3.Node{1, 1, 1}
+	for _, t := range m.Triangle {
+		for _, v := range t {
+			for i := range size {
+				if cur := int(v[i]/int64(scale)) + 2; cur > size[i] {
+					size[i] = cur
+				}
+			}
+		}
+	}
+	for i := range size {
+		if size[i] > n {
+			size[i] = n
+		}
+	}
+	return size
+}
+
+// drawSlices writes every 10th slice along z of the volume into zban-<z>.png.
+func drawSlices(vol volume.Space16, n int) {
 	timing.StartTiming("Rasterize.DrawSlices")
 	bmp := image.NewRGBA(image.Rect(0, 0, n, n))
 	for z := 1; z < n; z++ {
@@ -243,6 +315,4 @@ func Rasterize(m Mesh, n int) volume.Space16 {
 		}
 	}
 	timing.StopTiming("Rasterize.DrawSlices")
-	fmt.Fprintf(os.Stderr, "Rasterize complete\n")
-	return vol
 }
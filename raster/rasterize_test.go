@@ -0,0 +1,101 @@
+package raster
+
+import (
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+)
+
+// boxTriangles returns the surface of the box [0, a] × [0, b] × [0, c].
+func boxTriangles(a, b, c float64) []stl.Triangle {
+	var p [8]stl.Point
+	for i := range p {
+		if i&1 != 0 {
+			p[i][0] = a
+		}
+		if i&2 != 0 {
+			p[i][1] = b
+		}
+		if i&4 != 0 {
+			p[i][2] = c
+		}
+	}
+	var t []stl.Triangle
+	for _, f := range [][4]int{{0, 1, 3, 2}, {4, 5, 7, 6}, {0, 1, 5, 4}, {2, 3, 7, 6}, {0, 2, 6, 4}, {1, 3, 7, 5}} {
+		t = append(t,
+			stl.Triangle{V: [3]stl.Point{p[f[0]], p[f[1]], p[f[2]]}},
+			stl.Triangle{V: [3]stl.Point{p[f[0]], p[f[2]], p[f[3]]}})
+	}
+	return t
+}
+
+func TestRasterizeThinBox(t *testing.T) {
+	// The box is cropped so tightly, that every leaf cube contains its walls,
+	// and the outer space is only reachable through the sides of the volume.
+	t.Chdir(t.TempDir())
+	const n = 128
+	vol := Rasterize(STLToMesh(n, boxTriangles(40, 10, 10)), n)
+	size := vol.Size()
+	if size[0] != n || size[1] >= n/2 || size[2] >= n/2 {
+		t.Fatalf("Size: got %v, want the volume cropped to the box", size)
+	}
+	for _, p := range []g3.Node{{0, 0, 0}, {n - 1, size[1] - 1, size[2] - 1}, {0, 0, size[2] - 1}, {n / 2, 0, size[2] / 2}} {
+		if vol.Get(p) {
+			t.Errorf("Get(%v): want the outer space to be empty", p)
+		}
+	}
+	if c := (g3.Node{n / 2, size[1] / 2, size[2] / 2}); !vol.Get(c) {
+		t.Errorf("Get(%v): want the interior to be filled", c)
+	}
+	if v, all := vol.Volume(), int64(size[0]*size[1]*size[2]); v >= all*9/10 {
+		t.Errorf("Volume: got %d of %d voxels, want the outer space to be empty", v, all)
+	}
+}
+
+// shiftTriangles moves the triangles by d.
+func shiftTriangles(t []stl.Triangle, d stl.Point) []stl.Triangle {
+	for i := range t {
+		for j := range t[i].V {
+			for k := range d {
+				t[i].V[j][k] += d[k]
+			}
+		}
+	}
+	return t
+}
+
+func TestRasterizeOctree(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tests := []struct {
+		name string
+		t    []stl.Triangle
+	}{
+		{"thin box", boxTriangles(40, 10, 10)},
+		// The cavity is not reachable from the outside, so both fill it.
+		{"box with a cavity", append(boxTriangles(20, 20, 20), shiftTriangles(boxTriangles(8, 8, 8), stl.Point{6, 6, 6})...)},
+	}
+	const n = 128
+	for _, test := range tests {
+		m := STLToMesh(n, test.t)
+		want := Rasterize(m, n)
+		got := RasterizeOctree(m, n)
+		size := want.Size()
+		if got.Size() != size {
+			t.Fatalf("%s: Size: got %v, want %v", test.name, got.Size(), size)
+		}
+		if c := (g3.Node{size[0] / 2, size[1] / 2, size[2] / 2}); !want.Get(c) {
+			t.Fatalf("%s: Get(%v): want the center to be filled", test.name, c)
+		}
+		for x := 0; x < size[0]; x++ {
+			for y := 0; y < size[1]; y++ {
+				for z := 0; z < size[2]; z++ {
+					p := g3.Node{x, y, z}
+					if got.Get(p) != want.Get(p) {
+						t.Fatalf("%s: Get(%v): got %v, want %v", test.name, p, got.Get(p), want.Get(p))
+					}
+				}
+			}
+		}
+	}
+}
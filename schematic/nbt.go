@@ -0,0 +1,232 @@
+package schematic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// This file contains a minimal implementation of the NBT format
+// used by Minecraft. See http://wiki.vg/NBT for the specification.
+
+const (
+	tagEnd       = 0
+	tagByte      = 1
+	tagShort     = 2
+	tagInt       = 3
+	tagLong      = 4
+	tagFloat     = 5
+	tagDouble    = 6
+	tagByteArray = 7
+	tagString    = 8
+	tagList      = 9
+	tagCompound  = 10
+	tagIntArray  = 11
+	tagLongArray = 12
+)
+
+type nbtReader struct {
+	r   io.Reader
+	buf [8]byte
+}
+
+func (r *nbtReader) read(n int) ([]byte, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:n]); err != nil {
+		return nil, err
+	}
+	return r.buf[:n], nil
+}
+
+func (r *nbtReader) readByte() (byte, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *nbtReader) readShort() (int16, error) {
+	b, err := r.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return int16(binary.BigEndian.Uint16(b)), nil
+}
+
+func (r *nbtReader) readInt() (int32, error) {
+	b, err := r.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
+
+func (r *nbtReader) readString() (string, error) {
+	l, err := r.readShort()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, uint16(l))
+	if _, err = io.ReadFull(r.r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (r *nbtReader) readLen() (int, error) {
+	l, err := r.readInt()
+	if err != nil {
+		return 0, err
+	}
+	if l < 0 {
+		return 0, fmt.Errorf("schematic: bad array length: %d", l)
+	}
+	return int(l), nil
+}
+
+func (r *nbtReader) readByteArray() ([]byte, error) {
+	l, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	if _, err = io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readTag reads a tag header. The name is empty for tagEnd.
+func (r *nbtReader) readTag() (tag byte, name string, err error) {
+	if tag, err = r.readByte(); err != nil || tag == tagEnd {
+		return
+	}
+	name, err = r.readString()
+	return
+}
+
+// skip skips the payload of the tag.
+func (r *nbtReader) skip(tag byte) (err error) {
+	var size int64
+	switch tag {
+	case tagByte:
+		size = 1
+	case tagShort:
+		size = 2
+	case tagInt, tagFloat:
+		size = 4
+	case tagLong, tagDouble:
+		size = 8
+	case tagByteArray, tagIntArray, tagLongArray:
+		var l int
+		if l, err = r.readLen(); err != nil {
+			return
+		}
+		size = int64(l)
+		if tag == tagIntArray {
+			size *= 4
+		} else if tag == tagLongArray {
+			size *= 8
+		}
+	case tagString:
+		var l int16
+		if l, err = r.readShort(); err != nil {
+			return
+		}
+		size = int64(uint16(l))
+	case tagList:
+		var elem byte
+		if elem, err = r.readByte(); err != nil {
+			return
+		}
+		var l int
+		if l, err = r.readLen(); err != nil {
+			return
+		}
+		for i := 0; i < l; i++ {
+			if err = r.skip(elem); err != nil {
+				return
+			}
+		}
+		return
+	case tagCompound:
+		for {
+			var cur byte
+			if cur, _, err = r.readTag(); err != nil || cur == tagEnd {
+				return
+			}
+			if err = r.skip(cur); err != nil {
+				return
+			}
+		}
+	default:
+		return fmt.Errorf("schematic: unknown NBT tag: %d", tag)
+	}
+	_, err = io.CopyN(ioutil.Discard, r.r, size)
+	return
+}
+
+type nbtWriter struct {
+	w   io.Writer
+	buf [8]byte
+	err error
+}
+
+func (w *nbtWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(b)
+}
+
+func (w *nbtWriter) writeByte(b byte) {
+	w.buf[0] = b
+	w.write(w.buf[:1])
+}
+
+func (w *nbtWriter) writeShort(v int16) {
+	binary.BigEndian.PutUint16(w.buf[:2], uint16(v))
+	w.write(w.buf[:2])
+}
+
+func (w *nbtWriter) writeInt(v int32) {
+	binary.BigEndian.PutUint32(w.buf[:4], uint32(v))
+	w.write(w.buf[:4])
+}
+
+func (w *nbtWriter) writeString(s string) {
+	w.writeShort(int16(len(s)))
+	w.write([]byte(s))
+}
+
+func (w *nbtWriter) tag(tag byte, name string) {
+	w.writeByte(tag)
+	w.writeString(name)
+}
+
+func (w *nbtWriter) shortTag(name string, v int16) {
+	w.tag(tagShort, name)
+	w.writeShort(v)
+}
+
+func (w *nbtWriter) stringTag(name, s string) {
+	w.tag(tagString, name)
+	w.writeString(s)
+}
+
+func (w *nbtWriter) byteArrayTag(name string, b []byte) {
+	w.tag(tagByteArray, name)
+	w.writeInt(int32(len(b)))
+	w.write(b)
+}
+
+func (w *nbtWriter) emptyListTag(name string, elem byte) {
+	w.tag(tagList, name)
+	w.writeByte(elem)
+	w.writeInt(0)
+}
+
+func (w *nbtWriter) end() {
+	w.writeByte(tagEnd)
+}
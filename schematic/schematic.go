@@ -0,0 +1,199 @@
+// Package schematic reads and writes Minecraft .schematic files.
+// See http://minecraft.gamepedia.com/Schematic_file_format for the format description.
+//
+// Minecraft uses Y as the vertical axis, while this library uses Z, so
+// the block (x, y, z) of the schematic becomes the voxel (x, z, y).
+package schematic
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/volume"
+)
+
+// Color returns the voxel color for the block with the given id (0..4095) and data (0..15).
+// Air (id == 0, data == 0) maps to the empty voxel.
+func Color(id, data int) uint16 {
+	return uint16(id&0xFFF | (data&0xF)<<12)
+}
+
+// Block is the inverse of Color.
+func Block(color uint16) (id, data int) {
+	return int(color & 0xFFF), int(color >> 12)
+}
+
+// Read parses a gzipped .schematic file. It returns the volume and
+// the size of the schematic in voxels.
+func Read(r io.Reader) (vol volume.Space16, size g3.Node, err error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	defer zr.Close()
+
+	nr := &nbtReader{r: zr}
+	tag, _, err := nr.readTag()
+	if err != nil {
+		return
+	}
+	if tag != tagCompound {
+		err = fmt.Errorf("schematic: root tag is %d, want compound", tag)
+		return
+	}
+
+	var width, height, length int16
+	var blocks, data, addBlocks []byte
+	for {
+		var name string
+		if tag, name, err = nr.readTag(); err != nil {
+			return
+		}
+		if tag == tagEnd {
+			break
+		}
+		switch {
+		case tag == tagShort && name == "Width":
+			width, err = nr.readShort()
+		case tag == tagShort && name == "Height":
+			height, err = nr.readShort()
+		case tag == tagShort && name == "Length":
+			length, err = nr.readShort()
+		case tag == tagByteArray && name == "Blocks":
+			blocks, err = nr.readByteArray()
+		case tag == tagByteArray && name == "Data":
+			data, err = nr.readByteArray()
+		case tag == tagByteArray && name == "AddBlocks":
+			addBlocks, err = nr.readByteArray()
+		case tag == tagString && name == "Materials":
+			var materials string
+			if materials, err = nr.readString(); err == nil && materials != "Alpha" {
+				err = fmt.Errorf("schematic: unsupported materials: %q", materials)
+			}
+		default:
+			err = nr.skip(tag)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	if width < 0 || height < 0 || length < 0 {
+		err = fmt.Errorf("schematic: bad dimensions: %dx%dx%d", width, height, length)
+		return
+	}
+	total := int(width) * int(height) * int(length)
+	if len(blocks) != total {
+		err = fmt.Errorf("schematic: len(Blocks) = %d, want %d", len(blocks), total)
+		return
+	}
+	if data != nil && len(data) != total {
+		err = fmt.Errorf("schematic: len(Data) = %d, want %d", len(data), total)
+		return
+	}
+	if addBlocks != nil && len(addBlocks) != (total+1)/2 {
+		err = fmt.Errorf("schematic: len(AddBlocks) = %d, want %d", len(addBlocks), (total+1)/2)
+		return
+	}
+
+	size = g3.Node{int(width), int(length), int(height)}
+	// The volume has the size of the schematic, but it can't be empty, so the empty axes get a single voxel.
+	vsize := size
+	for i := range vsize {
+		if vsize[i] == 0 {
+			vsize[i] = 1
+		}
+	}
+	v := volume.NewSparseVolumeSize(vsize)
+	mapTiles(size, func(node g3.Node, i int) {
+		id := int(blocks[i])
+		if addBlocks != nil {
+			add := addBlocks[i>>1]
+			if i&1 == 0 {
+				add &= 0xF
+			} else {
+				add >>= 4
+			}
+			id |= int(add) << 8
+		}
+		var d int
+		if data != nil {
+			d = int(data[i])
+		}
+		if id != 0 || d != 0 {
+			v.Set16(node, Color(id, d))
+		}
+	})
+	return v, size, nil
+}
+
+// Write writes the box [0, size) of the volume as a gzipped .schematic file.
+// The voxel colors are converted to blocks with Block.
+func Write(w io.Writer, vol volume.Space16, size g3.Node) error {
+	for _, v := range size {
+		if v < 0 || v > 1<<15-1 {
+			return fmt.Errorf("schematic: bad size: %v", size)
+		}
+	}
+	total := size[0] * size[1] * size[2]
+	blocks := make([]byte, total)
+	data := make([]byte, total)
+	addBlocks := make([]byte, (total+1)/2)
+	hasAdd := false
+	mapTiles(size, func(node g3.Node, i int) {
+		id, d := Block(vol.Get16(node))
+		blocks[i] = byte(id)
+		data[i] = byte(d)
+		if add := byte(id >> 8); add != 0 {
+			hasAdd = true
+			if i&1 == 0 {
+				addBlocks[i>>1] |= add
+			} else {
+				addBlocks[i>>1] |= add << 4
+			}
+		}
+	})
+
+	zw := gzip.NewWriter(w)
+	nw := &nbtWriter{w: zw}
+	nw.tag(tagCompound, "Schematic")
+	nw.shortTag("Width", int16(size[0]))
+	nw.shortTag("Length", int16(size[1]))
+	nw.shortTag("Height", int16(size[2]))
+	nw.stringTag("Materials", "Alpha")
+	nw.byteArrayTag("Blocks", blocks)
+	nw.byteArrayTag("Data", data)
+	if hasAdd {
+		nw.byteArrayTag("AddBlocks", addBlocks)
+	}
+	nw.emptyListTag("Entities", tagCompound)
+	nw.emptyListTag("TileEntities", tagCompound)
+	nw.end()
+	if nw.err != nil {
+		return nw.err
+	}
+	return zw.Close()
+}
+
+// mapTiles invokes f on every voxel of the box [0, size) together with its index
+// in the schematic arrays. The voxels are visited in 32x32x32 tiles to match
+// the leaf cubes of volume.SparseVolume, which is much more cache friendly
+// than the Minecraft order.
+func mapTiles(size g3.Node, f func(node g3.Node, i int)) {
+	const tile = 32
+	for x0 := 0; x0 < size[0]; x0 += tile {
+		for y0 := 0; y0 < size[1]; y0 += tile {
+			for z0 := 0; z0 < size[2]; z0 += tile {
+				for x := x0; x < x0+tile && x < size[0]; x++ {
+					for y := y0; y < y0+tile && y < size[1]; y++ {
+						for z := z0; z < z0+tile && z < size[2]; z++ {
+							f(g3.Node{x, y, z}, (z*size[1]+y)*size[0]+x)
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package schematic
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/volume"
+)
+
+func roundTrip(t *testing.T, vol volume.Space16, size g3.Node) volume.Space16 {
+	var buf bytes.Buffer
+	if err := Write(&buf, vol, size); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, gotSize, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if gotSize != size {
+		t.Fatalf("Read: want size %v, got %v", size, gotSize)
+	}
+	if got.Size() != size {
+		t.Fatalf("Read: want the volume of size %v, got %v", size, got.Size())
+	}
+	return got
+}
+
+func TestBlocksRoundTrip(t *testing.T) {
+	size := g3.Node{5, 7, 3}
+	vol := volume.NewSparseVolume(32)
+	vol.Set16(g3.Node{0, 0, 0}, Color(1, 0))
+	vol.Set16(g3.Node{4, 6, 2}, Color(35, 14))
+	vol.Set16(g3.Node{1, 2, 1}, Color(0x234, 3))
+	vol.Set16(g3.Node{2, 2, 1}, Color(0xFFF, 15))
+
+	got := roundTrip(t, vol, size)
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				node := g3.Node{x, y, z}
+				if want, cur := vol.Get16(node), got.Get16(node); want != cur {
+					t.Errorf("%v: want color 0x%x, got 0x%x", node, want, cur)
+				}
+			}
+		}
+	}
+}
+
+func TestDevilRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the large model in short mode")
+	}
+	f, err := os.Open("../src/data/devil.schematic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	vol, size, err := Read(f)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if want := (g3.Node{512, 512, 512}); size != want {
+		t.Fatalf("Read: want size %v, got %v", want, size)
+	}
+	if vol.Volume() == 0 {
+		t.Fatalf("Read: the devil is empty")
+	}
+
+	got := roundTrip(t, vol, size)
+	if got.Volume() != vol.Volume() {
+		t.Fatalf("Volume: want %d, got %d", vol.Volume(), got.Volume())
+	}
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				node := g3.Node{x, y, z}
+				if want, cur := vol.Get16(node), got.Get16(node); want != cur {
+					t.Fatalf("%v: want color %d, got %d", node, want, cur)
+				}
+			}
+		}
+	}
+}
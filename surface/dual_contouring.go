@@ -0,0 +1,462 @@
+package surface
+
+import (
+	"math"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/triangle"
+	"github.com/krasin/voxel/volume"
+)
+
+// This file contains an implementation of Dual Contouring, as described in
+// "Dual Contouring of Hermite Data" by Tao Ju, Frank Losasso, Scott Schaefer and Joe Warren:
+// http://www.cs.wustl.edu/~taoju/research/dualContour.pdf
+//
+// Unlike Marching Cubes, it places one vertex per cube at the minimizer
+// of a quadratic error function (QEF) built from the intersection points and normals
+// on the edges of the cube, so sharp edges and corners are preserved.
+
+// HermiteData describes a surface sampled on a regular grid.
+type HermiteData interface {
+	// Size returns the number of grid nodes along each axis.
+	Size() [3]int
+	// Inside reports whether the grid node is inside of the solid.
+	Inside(x, y, z int) bool
+	// Intersection returns the point (in grid units) where the surface crosses
+	// the edge between the node (x, y, z) and its neighbour along axis,
+	// and the unit normal of the surface at that point.
+	// It's only called for the edges whose endpoints have different Inside values.
+	Intersection(x, y, z, axis int) (p, normal Vector)
+}
+
+// GradientField returns the gradient of a scalar field.
+type GradientField func(p g3.Point) g3.Vector
+
+// DualContouring extracts the surface from the Hermite data. The vertices are
+// multiplied by scale to convert them from grid units. The triangles are oriented
+// counterclockwise when viewed from the outside.
+func DualContouring(h HermiteData, scale Vector) *Mesh {
+	dc := &dualContour{
+		h:     h,
+		size:  h.Size(),
+		scale: scale,
+		m:     new(Mesh),
+		cells: make(map[int64]int),
+	}
+	s := dc.size
+	for x := 0; x < s[0]; x++ {
+		for y := 0; y < s[1]; y++ {
+			for z := 0; z < s[2]; z++ {
+				in := h.Inside(x, y, z)
+				for axis := 0; axis < 3; axis++ {
+					node := [3]int{x, y, z}
+					node[axis]++
+					if node[axis] >= s[axis] || h.Inside(node[0], node[1], node[2]) == in {
+						continue
+					}
+					dc.addQuad(x, y, z, axis, in)
+				}
+			}
+		}
+	}
+	return dc.m
+}
+
+type dualContour struct {
+	h     HermiteData
+	size  [3]int
+	scale Vector
+	m     *Mesh
+	// cells maps the cell key to the index of its vertex.
+	cells map[int64]int
+}
+
+// addQuad connects the vertices of the four cells around the edge.
+func (dc *dualContour) addQuad(x, y, z, axis int, in bool) {
+	// u and v are the other two axes, so that (axis, u, v) is a right-handed basis.
+	u, v := (axis+1)%3, (axis+2)%3
+	var quad [4]int
+	for i, d := range [4][2]int{{-1, -1}, {0, -1}, {0, 0}, {-1, 0}} {
+		cell := [3]int{x, y, z}
+		cell[u] += d[0]
+		cell[v] += d[1]
+		if cell[u] < 0 || cell[v] < 0 || cell[u]+1 >= dc.size[u] || cell[v]+1 >= dc.size[v] {
+			// The surface touches the boundary of the grid.
+			return
+		}
+		quad[i] = dc.vertex(cell)
+	}
+	if !in {
+		// The solid is on the positive side of the edge, so the surface looks backwards.
+		quad[1], quad[3] = quad[3], quad[1]
+	}
+	dc.m.Triangle = append(dc.m.Triangle, [3]int{quad[0], quad[1], quad[2]}, [3]int{quad[0], quad[2], quad[3]})
+}
+
+// vertex returns the index of the vertex for the cell, computing it, if necessary.
+func (dc *dualContour) vertex(cell [3]int) int {
+	key := (int64(cell[0])*int64(dc.size[1])+int64(cell[1]))*int64(dc.size[2]) + int64(cell[2])
+	if ind, ok := dc.cells[key]; ok {
+		return ind
+	}
+
+	var q qef
+	var normal Vector
+	for iEdge := 0; iEdge < 12; iEdge++ {
+		a := a2iEdgeBase[iEdge]
+		axis := aiEdgeAxis[iEdge]
+		n0 := [3]int{cell[0] + a[0], cell[1] + a[1], cell[2] + a[2]}
+		n1 := n0
+		n1[axis]++
+		if dc.h.Inside(n0[0], n0[1], n0[2]) == dc.h.Inside(n1[0], n1[1], n1[2]) {
+			continue
+		}
+		p, nv := dc.h.Intersection(n0[0], n0[1], n0[2], axis)
+		q.add(p, nv)
+		normal = AddVector(normal, nv)
+	}
+	p := q.solve()
+	// Keep the vertex inside of the cell. Otherwise, the mesh may self-intersect.
+	mass := q.massPoint()
+	for i, c := range [3]float64{p.X, p.Y, p.Z} {
+		if c < float64(cell[i]) || c > float64(cell[i]+1) {
+			p = mass
+			break
+		}
+	}
+
+	ind := len(dc.m.Vertex)
+	dc.cells[key] = ind
+	dc.m.Vertex = append(dc.m.Vertex, Vector{p.X * dc.scale.X, p.Y * dc.scale.Y, p.Z * dc.scale.Z})
+	dc.m.Normal = append(dc.m.Normal, normalizeVector(Vector{normal.X / dc.scale.X, normal.Y / dc.scale.Y, normal.Z / dc.scale.Z}))
+	return ind
+}
+
+// DualContouringField samples the field over the unit cube like MarchingCubes
+// and extracts the surface with Dual Contouring. The points with values above the threshold
+// are considered to be inside. If grad is nil, the gradient is estimated with central differences.
+func DualContouringField(field g3.ScalarField, grad GradientField, n int, threshold float64, size Vector) *Mesh {
+	h := &fieldHermite{
+		field:     field,
+		grad:      grad,
+		n:         n,
+		threshold: threshold,
+		values:    make([]float64, (n+1)*(n+1)*(n+1)),
+	}
+	step := 1.0 / float64(n)
+	for x := 0; x <= n; x++ {
+		for y := 0; y <= n; y++ {
+			for z := 0; z <= n; z++ {
+				h.values[h.index(x, y, z)] = field(g3.Point{float64(x) * step, float64(y) * step, float64(z) * step})
+			}
+		}
+	}
+	return DualContouring(h, Vector{size.X * step, size.Y * step, size.Z * step})
+}
+
+type fieldHermite struct {
+	field     g3.ScalarField
+	grad      GradientField
+	n         int
+	threshold float64
+	values    []float64
+}
+
+func (h *fieldHermite) index(x, y, z int) int {
+	return (x*(h.n+1)+y)*(h.n+1) + z
+}
+
+func (h *fieldHermite) Size() [3]int {
+	return [3]int{h.n + 1, h.n + 1, h.n + 1}
+}
+
+func (h *fieldHermite) Inside(x, y, z int) bool {
+	return h.values[h.index(x, y, z)] > h.threshold
+}
+
+func (h *fieldHermite) Intersection(x, y, z, axis int) (p, normal Vector) {
+	n1 := [3]int{x, y, z}
+	n1[axis]++
+	v0 := h.values[h.index(x, y, z)]
+	v1 := h.values[h.index(n1[0], n1[1], n1[2])]
+
+	// Refine the linear estimate with a few steps of the false position method.
+	step := 1.0 / float64(h.n)
+	at := func(t float64) g3.Point {
+		q := g3.Point{float64(x) * step, float64(y) * step, float64(z) * step}
+		q[axis] += t * step
+		return q
+	}
+	t0, t1 := 0.0, 1.0
+	t := fGetOffset(v0, v1, h.threshold)
+	for i := 0; i < 4; i++ {
+		v := h.field(at(t))
+		if (v > h.threshold) == (v0 > h.threshold) {
+			t0, v0 = t, v
+		} else {
+			t1, v1 = t, v
+		}
+		t = t0 + (t1-t0)*fGetOffset(v0, v1, h.threshold)
+	}
+
+	pos := [3]float64{float64(x), float64(y), float64(z)}
+	pos[axis] += t
+	p = Vector{pos[0], pos[1], pos[2]}
+
+	q := at(t)
+	if h.grad != nil {
+		g := h.grad(q)
+		normal = Vector{-g[0], -g[1], -g[2]}
+	} else {
+		d := step / 10
+		normal.X = h.field(g3.Point{q[0] - d, q[1], q[2]}) - h.field(g3.Point{q[0] + d, q[1], q[2]})
+		normal.Y = h.field(g3.Point{q[0], q[1] - d, q[2]}) - h.field(g3.Point{q[0], q[1] + d, q[2]})
+		normal.Z = h.field(g3.Point{q[0], q[1], q[2] - d}) - h.field(g3.Point{q[0], q[1], q[2] + d})
+	}
+	return p, normalizeVector(normal)
+}
+
+// DualContouringVolume extracts the surface of the voxel volume with Dual Contouring.
+// The Hermite data is computed from the original triangles, which are given
+// in the mesh units (see raster.Mesh), and scale is the number of mesh units per voxel.
+// Like in MarchingCubes, the volume occupies the unit cube, which is then scaled by size.
+func DualContouringVolume(vol volume.Space16, triangles []triangle.Triangle, scale int64, size Vector) *Mesh {
+	n := vol.N()
+	h := &volumeHermite{
+		vol:   vol,
+		n:     n,
+		edges: make(map[int64]hermitePoint),
+	}
+	for _, t := range triangles {
+		h.addTriangle(t, float64(scale))
+	}
+	step := 1.0 / float64(n)
+	return DualContouring(h, Vector{size.X * step, size.Y * step, size.Z * step})
+}
+
+type hermitePoint struct {
+	p, normal Vector
+}
+
+type volumeHermite struct {
+	vol   volume.Space16
+	n     int
+	edges map[int64]hermitePoint
+}
+
+func (h *volumeHermite) key(x, y, z, axis int) int64 {
+	n := int64(h.n)
+	return ((int64(x)*n+int64(y))*n+int64(z))*3 + int64(axis)
+}
+
+func (h *volumeHermite) Size() [3]int {
+	return h.vol.Size()
+}
+
+func (h *volumeHermite) Inside(x, y, z int) bool {
+	return h.vol.Get(g3.Node{x, y, z})
+}
+
+func (h *volumeHermite) Intersection(x, y, z, axis int) (p, normal Vector) {
+	if hp, ok := h.edges[h.key(x, y, z, axis)]; ok {
+		return hp.p, hp.normal
+	}
+	// The triangles do not cross the edge, which happens with leaky meshes.
+	// Fall back to the middle of the edge and the normal along the edge.
+	pos := [3]float64{float64(x), float64(y), float64(z)}
+	pos[axis] += 0.5
+	var nv [3]float64
+	nv[axis] = 1
+	if !h.Inside(x, y, z) {
+		nv[axis] = -1
+	}
+	return Vector{pos[0], pos[1], pos[2]}, Vector{nv[0], nv[1], nv[2]}
+}
+
+// addTriangle finds all grid edges crossed by the triangle and records the Hermite data for them.
+func (h *volumeHermite) addTriangle(t triangle.Triangle, scale float64) {
+	var v [3][3]float64
+	for i := range t {
+		for j := range t[i] {
+			v[i][j] = float64(t[i][j]) / scale
+		}
+	}
+	e1 := [3]float64{v[1][0] - v[0][0], v[1][1] - v[0][1], v[1][2] - v[0][2]}
+	e2 := [3]float64{v[2][0] - v[0][0], v[2][1] - v[0][1], v[2][2] - v[0][2]}
+	nv := normalizeVector(CrossProduct(Vector{e1[0], e1[1], e1[2]}, Vector{e2[0], e2[1], e2[2]}))
+	nn := [3]float64{nv.X, nv.Y, nv.Z}
+
+	for axis := 0; axis < 3; axis++ {
+		if nn[axis] == 0 {
+			// The triangle is parallel to the grid lines.
+			continue
+		}
+		u, w := (axis+1)%3, (axis+2)%3
+		uMin, uMax := bounds(v[0][u], v[1][u], v[2][u])
+		wMin, wMax := bounds(v[0][w], v[1][w], v[2][w])
+		for gu := int(math.Ceil(uMin)); float64(gu) <= uMax; gu++ {
+			for gw := int(math.Ceil(wMin)); float64(gw) <= wMax; gw++ {
+				if !inTriangle2(float64(gu), float64(gw), v, u, w) {
+					continue
+				}
+				// Intersect the grid line with the plane of the triangle.
+				c := nn[u]*(float64(gu)-v[0][u]) + nn[w]*(float64(gw)-v[0][w])
+				ta := v[0][axis] - c/nn[axis]
+				ga := int(math.Floor(ta))
+				var node [3]int
+				node[axis], node[u], node[w] = ga, gu, gw
+				if !h.validEdge(node, axis) {
+					continue
+				}
+				var pos [3]float64
+				pos[axis], pos[u], pos[w] = ta, float64(gu), float64(gw)
+				key := h.key(node[0], node[1], node[2], axis)
+				if _, ok := h.edges[key]; !ok {
+					h.edges[key] = hermitePoint{p: Vector{pos[0], pos[1], pos[2]}, normal: nv}
+				}
+			}
+		}
+	}
+}
+
+func (h *volumeHermite) validEdge(node [3]int, axis int) bool {
+	for i, c := range node {
+		if c < 0 || c >= h.n || (i == axis && c+1 >= h.n) {
+			return false
+		}
+	}
+	return true
+}
+
+func bounds(a, b, c float64) (min, max float64) {
+	return math.Min(a, math.Min(b, c)), math.Max(a, math.Max(b, c))
+}
+
+// inTriangle2 reports whether the point (pu, pw) lies within the projection
+// of the triangle onto the plane of the axes u and w.
+func inTriangle2(pu, pw float64, v [3][3]float64, u, w int) bool {
+	var pos, neg bool
+	for i := 0; i < 3; i++ {
+		a, b := v[i], v[(i+1)%3]
+		s := (b[u]-a[u])*(pw-a[w]) - (b[w]-a[w])*(pu-a[u])
+		if s > 0 {
+			pos = true
+		}
+		if s < 0 {
+			neg = true
+		}
+	}
+	return !(pos && neg)
+}
+
+// qef accumulates the quadratic error function sum((n_i * (x - p_i))^2)
+// in the form x^T A x - 2 x^T b + c, and the mass point of the p_i.
+type qef struct {
+	ata  [3][3]float64
+	atb  [3]float64
+	mass [3]float64
+	cnt  int
+}
+
+func (q *qef) add(p, normal Vector) {
+	nv := [3]float64{normal.X, normal.Y, normal.Z}
+	pv := [3]float64{p.X, p.Y, p.Z}
+	d := nv[0]*pv[0] + nv[1]*pv[1] + nv[2]*pv[2]
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			q.ata[i][j] += nv[i] * nv[j]
+		}
+		q.atb[i] += nv[i] * d
+		q.mass[i] += pv[i]
+	}
+	q.cnt++
+}
+
+func (q *qef) massPoint() Vector {
+	if q.cnt == 0 {
+		return Vector{}
+	}
+	c := float64(q.cnt)
+	return Vector{q.mass[0] / c, q.mass[1] / c, q.mass[2] / c}
+}
+
+// qefTruncation is the relative threshold below which the eigenvalues of A are ignored.
+// It makes the solution stable for flat and cylindrical regions,
+// where the QEF has no unique minimum.
+const qefTruncation = 0.1
+
+// solve returns the minimizer of the QEF, which is the closest to the mass point.
+func (q *qef) solve() Vector {
+	c := q.massPoint()
+	cv := [3]float64{c.X, c.Y, c.Z}
+	// Solve A (x - c) = b - A c with the pseudo-inverse of A.
+	var r [3]float64
+	for i := 0; i < 3; i++ {
+		r[i] = q.atb[i]
+		for j := 0; j < 3; j++ {
+			r[i] -= q.ata[i][j] * cv[j]
+		}
+	}
+	val, vec := eigenSym3(q.ata)
+	maxVal := math.Max(math.Abs(val[0]), math.Max(math.Abs(val[1]), math.Abs(val[2])))
+	var x [3]float64
+	for k := 0; k < 3; k++ {
+		if maxVal == 0 || math.Abs(val[k]) < qefTruncation*maxVal {
+			continue
+		}
+		// Project r onto the eigenvector and scale by the inverse eigenvalue.
+		s := (vec[0][k]*r[0] + vec[1][k]*r[1] + vec[2][k]*r[2]) / val[k]
+		for i := 0; i < 3; i++ {
+			x[i] += s * vec[i][k]
+		}
+	}
+	return Vector{cv[0] + x[0], cv[1] + x[1], cv[2] + x[2]}
+}
+
+// eigenSym3 computes the eigenvalues and eigenvectors (stored in columns)
+// of a symmetric 3x3 matrix with the Jacobi eigenvalue algorithm.
+func eigenSym3(a [3][3]float64) (val [3]float64, vec [3][3]float64) {
+	for i := 0; i < 3; i++ {
+		vec[i][i] = 1
+	}
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off < 1e-24 {
+			break
+		}
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				// Apply the rotation: a = J^T a J, vec = vec J.
+				for k := 0; k < 3; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp, vkq := vec[k][p], vec[k][q]
+					vec[k][p] = c*vkp - s*vkq
+					vec[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+	for i := 0; i < 3; i++ {
+		val[i] = a[i][i]
+	}
+	return
+}
@@ -0,0 +1,97 @@
+package surface
+
+import (
+	"math"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/triangle"
+	"github.com/krasin/voxel/volume"
+)
+
+// boxField is positive inside of the box [0.2, 0.7] x [0.25, 0.65] x [0.3, 0.8].
+func boxField(p g3.Point) float64 {
+	lo := [3]float64{0.2, 0.25, 0.3}
+	hi := [3]float64{0.7, 0.65, 0.8}
+	res := math.Inf(1)
+	for i := range p {
+		res = math.Min(res, math.Min(p[i]-lo[i], hi[i]-p[i]))
+	}
+	return res
+}
+
+// nearestVertex returns the distance from p to the closest vertex of the mesh.
+func nearestVertex(m *Mesh, p Vector) float64 {
+	res := math.Inf(1)
+	for _, v := range m.Vertex {
+		d := SubVector(v, p)
+		res = math.Min(res, math.Sqrt(DotProduct(d, d)))
+	}
+	return res
+}
+
+func TestDualContouringField(t *testing.T) {
+	m := DualContouringField(boxField, nil, 32, 0, Vector{1, 1, 1})
+	checkClosed(t, m)
+	if got, want := signedVolume(m), 0.5*0.4*0.5; math.Abs(got-want) > 1e-3 {
+		t.Errorf("signed volume: want %f, got %f", want, got)
+	}
+	// Dual Contouring must preserve the corners of the box,
+	// which Marching Cubes cuts off.
+	for _, corner := range []Vector{{0.2, 0.25, 0.3}, {0.7, 0.65, 0.8}, {0.2, 0.65, 0.3}} {
+		if d := nearestVertex(m, corner); d > 1e-3 {
+			t.Errorf("corner %v: the closest vertex is %f away", corner, d)
+		}
+	}
+}
+
+func TestDualContouringVolume(t *testing.T) {
+	// The box of voxels [8, 20) x [8, 20) x [10, 24) with scale 2,
+	// so the surface crosses the grid edges at half-voxels.
+	const scale = 2
+	lo := triangle.Point{15, 15, 19}
+	hi := triangle.Point{39, 39, 47}
+	corner := func(i int) triangle.Point {
+		p := lo
+		for j := 0; j < 3; j++ {
+			if i&(1<<uint(j)) != 0 {
+				p[j] = hi[j]
+			}
+		}
+		return p
+	}
+	// The faces of the box as quads of corner indices, oriented outwards.
+	faces := [6][4]int{
+		{0, 4, 6, 2}, {1, 3, 7, 5},
+		{0, 1, 5, 4}, {2, 6, 7, 3},
+		{0, 2, 3, 1}, {4, 5, 7, 6},
+	}
+	var triangles []triangle.Triangle
+	for _, f := range faces {
+		triangles = append(triangles,
+			triangle.Triangle{corner(f[0]), corner(f[1]), corner(f[2])},
+			triangle.Triangle{corner(f[0]), corner(f[2]), corner(f[3])})
+	}
+
+	// The volume cut right after the box must give the same surface as the cube.
+	for _, size := range []g3.Node{{32, 32, 32}, {32, 21, 25}} {
+		vol := volume.NewSparseVolumeSize(size)
+		for x := 8; x < 20; x++ {
+			for y := 8; y < 20; y++ {
+				for z := 10; z < 24; z++ {
+					vol.Set16(g3.Node{x, y, z}, 1)
+				}
+			}
+		}
+		m := DualContouringVolume(vol, triangles, scale, Vector{32, 32, 32})
+		checkClosed(t, m)
+		if got, want := signedVolume(m), 12.0*12*14; math.Abs(got-want) > 1e-6 {
+			t.Errorf("size %v: signed volume: want %f, got %f", size, want, got)
+		}
+		for _, c := range []Vector{{7.5, 7.5, 9.5}, {19.5, 19.5, 23.5}} {
+			if d := nearestVertex(m, c); d > 1e-6 {
+				t.Errorf("size %v: corner %v: the closest vertex is %f away", size, c, d)
+			}
+		}
+	}
+}
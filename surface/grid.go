@@ -0,0 +1,79 @@
+package surface
+
+import (
+	"math"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+)
+
+// Grid is a regular grid of samples in the world coordinates.
+// Unlike the grid of MarchingCubes, it may have different number of samples
+// and different spacing along each axis, and it may start at any point.
+type Grid struct {
+	// N is the number of cubes along each axis. There are N+1 samples along each axis.
+	N [3]int
+	// Origin is the position of the sample (0, 0, 0).
+	Origin Vector
+	// Spacing is the distance between adjacent samples along each axis.
+	Spacing Vector
+}
+
+// NewGrid returns a grid with cubic cells of side h, which covers the box [min, max].
+func NewGrid(min, max Vector, h float64) Grid {
+	count := func(lo, hi float64) int {
+		n := int(math.Ceil((hi - lo) / h))
+		if n < 1 {
+			n = 1
+		}
+		return n
+	}
+	return Grid{
+		N:       [3]int{count(min.X, max.X), count(min.Y, max.Y), count(min.Z, max.Z)},
+		Origin:  min,
+		Spacing: Vector{h, h, h},
+	}
+}
+
+// NewGridFrom returns a grid with n cubes along each axis, which covers
+// the same cube as g, e.g. the Grid of raster.Mesh.
+func NewGridFrom(g g3.Grid, n [3]int) Grid {
+	side := g.Side()
+	return Grid{
+		N:       n,
+		Origin:  Vector{g.P0[0], g.P0[1], g.P0[2]},
+		Spacing: Vector{side / float64(n[0]), side / float64(n[1]), side / float64(n[2])},
+	}
+}
+
+// At returns the position of the sample (x, y, z).
+func (g Grid) At(x, y, z int) Vector {
+	return Vector{
+		g.Origin.X + float64(x)*g.Spacing.X,
+		g.Origin.Y + float64(y)*g.Spacing.Y,
+		g.Origin.Z + float64(z)*g.Spacing.Z,
+	}
+}
+
+func (g Grid) marcher(field g3.ScalarField, threshold float64) *marcher {
+	for _, v := range g.N {
+		if v < 1 {
+			panic("surface: Grid.N must be positive")
+		}
+	}
+	p0 := [3]float64{g.Origin.X, g.Origin.Y, g.Origin.Z}
+	step := [3]float64{g.Spacing.X, g.Spacing.Y, g.Spacing.Z}
+	return newMarcher(field, g.N, p0, step, threshold, Vector{1, 1, 1})
+}
+
+// MarchingCubesGrid runs Marching Cubes over the grid. The field is sampled
+// in the world coordinates, and the output is in the world coordinates too.
+// The slabs are processed with the given number of goroutines, see MarchingCubesParallel.
+func MarchingCubesGrid(field g3.ScalarField, g Grid, threshold float64, workers int) []stl.Triangle {
+	return marchSoup(g.marcher(field, threshold), workers)
+}
+
+// MarchingCubesGridMesh is like MarchingCubesGrid, but it returns an indexed mesh.
+func MarchingCubesGridMesh(field g3.ScalarField, g Grid, threshold float64, workers int) *Mesh {
+	return marchMesh(g.marcher(field, threshold), workers)
+}
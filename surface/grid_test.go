@@ -0,0 +1,42 @@
+package surface
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/krasin/g3"
+)
+
+func TestMarchingCubesGrid(t *testing.T) {
+	const n = 20
+	unit := Grid{N: [3]int{n, n, n}, Spacing: Vector{1.0 / n, 1.0 / n, 1.0 / n}}
+	if got, want := MarchingCubesGrid(sphereField, unit, 0, 1), MarchingCubes(sphereField, n, 0, Vector{1, 1, 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("MarchingCubesGrid over the unit cube differs from MarchingCubes")
+	}
+
+	// A long thin ellipsoid far from the origin.
+	center := Vector{1000, -20, 5}
+	radius := Vector{100, 4, 3}
+	ellipsoid := func(p g3.Point) float64 {
+		dx, dy, dz := (p[0]-center.X)/radius.X, (p[1]-center.Y)/radius.Y, (p[2]-center.Z)/radius.Z
+		return 1 - math.Sqrt(dx*dx+dy*dy+dz*dz)
+	}
+	min := SubVector(center, Vector{101, 5, 4})
+	max := AddVector(center, Vector{101, 5, 4})
+	g := NewGrid(min, max, 0.25)
+	if want := [3]int{808, 40, 32}; g.N != want {
+		t.Fatalf("NewGrid: want N=%v, got %v", want, g.N)
+	}
+	m := MarchingCubesGridMesh(ellipsoid, g, 0, 0)
+	checkClosed(t, m)
+	for _, v := range m.Vertex {
+		if v.X < min.X || v.X > max.X || v.Y < min.Y || v.Y > max.Y || v.Z < min.Z || v.Z > max.Z {
+			t.Fatalf("vertex %v is out of the box [%v, %v]", v, min, max)
+		}
+	}
+	want := 4 * math.Pi / 3 * radius.X * radius.Y * radius.Z
+	if got := signedVolume(m); math.Abs(got-want) > 0.02*want {
+		t.Errorf("signed volume: want %f, got %f", want, got)
+	}
+}
@@ -0,0 +1,389 @@
+package surface
+
+import (
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+)
+
+// marcher runs Marching Cubes over a grid slab by slab.
+// Every grid node is sampled exactly once, and only the planes of samples
+// around the current slab are kept in memory. The normals are taken from
+// the gradient of the samples, so the field is never probed between the grid nodes.
+type marcher struct {
+	field     g3.ScalarField
+	threshold float64
+	// n is the number of cubes along each axis.
+	n [3]int
+	// The grid node (x, y, z) is sampled at p0 + (x, y, z) * step.
+	p0, step [3]float64
+	// size is the scale applied to the output.
+	size Vector
+	// flip is true if the grid and the scale mirror the space,
+	// so the triangles must be flipped to stay oriented outwards.
+	flip bool
+	// mc33, if true, makes the marcher resolve the ambiguous cubes
+	// like Marching Cubes 33 instead of using a2iTriangleConnectionTable.
+	mc33 bool
+	// node, if not nil, is used instead of field to sample the grid node (x, y, z).
+	node func(x, y, z int) float64
+
+	// x is the current slab.
+	x int
+	// planes contain the samples of the planes x-1, x, x+1 and x+2. The planes x-1 and x+2
+	// are only used for the gradients, and they are not sampled outside of the grid.
+	planes [4][]float64
+}
+
+func newMarcher(field g3.ScalarField, n [3]int, p0, step [3]float64, threshold float64, size Vector) *marcher {
+	m := &marcher{
+		field:     field,
+		threshold: threshold,
+		n:         n,
+		p0:        p0,
+		step:      step,
+		size:      size,
+		flip:      step[0]*step[1]*step[2]*size.X*size.Y*size.Z < 0,
+	}
+	for i := range m.planes {
+		m.planes[i] = make([]float64, (n[1]+1)*(n[2]+1))
+	}
+	return m
+}
+
+// clone returns a marcher with the same parameters, which can run concurrently with m.
+func (m *marcher) clone() *marcher {
+	m2 := newMarcher(m.field, m.n, m.p0, m.step, m.threshold, m.size)
+	m2.node = m.node
+	m2.mc33 = m.mc33
+	return m2
+}
+
+// cube describes a single cube intersected by the surface.
+type cube struct {
+	x, y, z int
+	// fX, fY, fZ are the coordinates of the vertex 0 of the cube.
+	fX, fY, fZ float64
+	iFlagIndex int
+	iEdgeFlags int
+	afValue    [8]float64
+
+	// triangles are filled by polygonise. They refer to the vertices on the edges
+	// of the cube by the edge numbers, and the vertex 12+i is the average of
+	// the vertices on the edges listed in centers[i].
+	triangles [][3]int
+	centers   [][]int
+}
+
+// emitter receives the cubes intersected by the surface and builds the output.
+type emitter interface {
+	cube(m *marcher, c *cube)
+	// nextSlab is called after all cubes of the slab are processed.
+	nextSlab()
+}
+
+// index returns the index of the node (y, z) within a plane.
+func (m *marcher) index(y, z int) int {
+	return y*(m.n[2]+1) + z
+}
+
+func (m *marcher) coord(axis, i int) float64 {
+	return m.p0[axis] + float64(i)*m.step[axis]
+}
+
+// sample fills buf with the samples of the plane x.
+func (m *marcher) sample(x int, buf []float64) {
+	if m.node != nil {
+		for y := 0; y <= m.n[1]; y++ {
+			for z := 0; z <= m.n[2]; z++ {
+				buf[m.index(y, z)] = m.node(x, y, z)
+			}
+		}
+		return
+	}
+	fx := m.coord(0, x)
+	for y := 0; y <= m.n[1]; y++ {
+		fy := m.coord(1, y)
+		for z := 0; z <= m.n[2]; z++ {
+			buf[m.index(y, z)] = m.field(g3.Point{fx, fy, m.coord(2, z)})
+		}
+	}
+}
+
+// run processes the slabs [x0, x1).
+func (m *marcher) run(x0, x1 int, e emitter) {
+	for i := range m.planes {
+		if x := x0 - 1 + i; x >= 0 && x <= m.n[0] {
+			m.sample(x, m.planes[i])
+		}
+	}
+	for x := x0; x < x1; x++ {
+		if x > x0 {
+			p := m.planes
+			m.planes = [4][]float64{p[1], p[2], p[3], p[0]}
+			if x+2 <= m.n[0] {
+				m.sample(x+2, m.planes[3])
+			}
+		}
+		m.x = x
+		m.slab(x, e)
+		e.nextSlab()
+	}
+}
+
+// value returns the sample of the grid node (x, y, z), where x is within [m.x-1, m.x+2].
+func (m *marcher) value(x, y, z int) float64 {
+	return m.planes[x-m.x+1][m.index(y, z)]
+}
+
+// gradient returns the gradient of the samples at the grid node (x, y, z) of the current slab
+// in the coordinates of the field. It takes the central differences, or the one-sided ones
+// at the boundary of the grid.
+func (m *marcher) gradient(x, y, z int) (g Vector) {
+	x0, x1 := neighbours(x, m.n[0])
+	y0, y1 := neighbours(y, m.n[1])
+	z0, z1 := neighbours(z, m.n[2])
+	g.X = (m.value(x1, y, z) - m.value(x0, y, z)) / (float64(x1-x0) * m.step[0])
+	g.Y = (m.value(x, y1, z) - m.value(x, y0, z)) / (float64(y1-y0) * m.step[1])
+	g.Z = (m.value(x, y, z1) - m.value(x, y, z0)) / (float64(z1-z0) * m.step[2])
+	return
+}
+
+// neighbours returns the nodes around i along the axis with n cubes, which are used for the differences.
+func neighbours(i, n int) (i0, i1 int) {
+	i0, i1 = i-1, i+1
+	if i0 < 0 {
+		i0 = i
+	}
+	if i1 > n {
+		i1 = i
+	}
+	return
+}
+
+func (m *marcher) slab(x int, e emitter) {
+	var c cube
+	c.x = x
+	c.fX = m.coord(0, x)
+	for y := 0; y < m.n[1]; y++ {
+		c.y = y
+		c.fY = m.coord(1, y)
+		for z := 0; z < m.n[2]; z++ {
+			c.z = z
+			//Make a local copy of the values at the cube's corners
+			for iVertex := 0; iVertex < 8; iVertex++ {
+				o := a2iVertexOffset[iVertex]
+				c.afValue[iVertex] = m.planes[1+o[0]][m.index(y+o[1], z+o[2])]
+			}
+
+			//Find which vertices are inside of the surface and which are outside
+			c.iFlagIndex = 0
+			for iVertexTest := 0; iVertexTest < 8; iVertexTest++ {
+				if c.afValue[iVertexTest] <= m.threshold {
+					c.iFlagIndex |= 1 << uint(iVertexTest)
+				}
+			}
+
+			//If the cube is entirely inside or outside of the surface, then there will be no intersections
+			c.iEdgeFlags = aiCubeEdgeFlags[c.iFlagIndex]
+			if c.iEdgeFlags == 0 {
+				continue
+			}
+			c.fZ = m.coord(2, z)
+			e.cube(m, &c)
+		}
+	}
+}
+
+// edgeVertex returns the point of intersection of the surface with the edge
+// in the coordinates of the field.
+func (m *marcher) edgeVertex(c *cube, iEdge int) (v Vector) {
+	i0 := a2iEdgeConnection[iEdge][0]
+	fOffset := fGetOffset(c.afValue[i0], c.afValue[a2iEdgeConnection[iEdge][1]], m.threshold)
+
+	v.X = c.fX + (a2fVertexOffset[i0][0]+fOffset*a2fEdgeDirection[iEdge][0])*m.step[0]
+	v.Y = c.fY + (a2fVertexOffset[i0][1]+fOffset*a2fEdgeDirection[iEdge][1])*m.step[1]
+	v.Z = c.fZ + (a2fVertexOffset[i0][2]+fOffset*a2fEdgeDirection[iEdge][2])*m.step[2]
+	return
+}
+
+// scale maps the point of the field to the output coordinates.
+func (m *marcher) scale(v Vector) Vector {
+	return Vector{m.size.X * v.X, m.size.Y * v.Y, m.size.Z * v.Z}
+}
+
+// normal returns the normal of the surface at the vertex on the edge of the cube in the output coordinates.
+// The gradients at the ends of the edge are interpolated like the vertex itself.
+func (m *marcher) normal(c *cube, iEdge int) Vector {
+	i0, i1 := a2iEdgeConnection[iEdge][0], a2iEdgeConnection[iEdge][1]
+	fOffset := fGetOffset(c.afValue[i0], c.afValue[i1], m.threshold)
+	o0, o1 := a2iVertexOffset[i0], a2iVertexOffset[i1]
+	g0 := m.gradient(c.x+o0[0], c.y+o0[1], c.z+o0[2])
+	g1 := m.gradient(c.x+o1[0], c.y+o1[1], c.z+o1[2])
+	g := AddVector(g0, ScaleVector(SubVector(g1, g0), fOffset))
+	// The normal looks against the gradient, i.e. from the higher values to the lower ones, like vGetNormal.
+	// The gradient is a covector, so it's divided by the scale, not multiplied.
+	return normalizeVector(Vector{-g.X / m.size.X, -g.Y / m.size.Y, -g.Z / m.size.Z})
+}
+
+// polygonise fills c.triangles and c.centers. The triangles are counterclockwise
+// when viewed from the outside.
+func (m *marcher) polygonise(c *cube) {
+	c.triangles = c.triangles[:0]
+	c.centers = c.centers[:0]
+	if m.mc33 {
+		polygonise33(c, m.threshold)
+	} else {
+		row := &a2iTriangleConnectionTable[c.iFlagIndex]
+		for i := 0; row[i] >= 0; i += 3 {
+			c.triangles = append(c.triangles, [3]int{row[i], row[i+1], row[i+2]})
+		}
+	}
+	if m.flip {
+		for i := range c.triangles {
+			c.triangles[i][1], c.triangles[i][2] = c.triangles[i][2], c.triangles[i][1]
+		}
+	}
+}
+
+// a2iVertexOffset is a2fVertexOffset in integers.
+var a2iVertexOffset = [8][3]int{
+	{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0},
+	{0, 0, 1}, {1, 0, 1}, {1, 1, 1}, {0, 1, 1},
+}
+
+// soupEmitter builds a triangle soup.
+type soupEmitter struct {
+	t []stl.Triangle
+	// vertex is a buffer for the vertices of the current cube.
+	vertex []Vector
+}
+
+func (e *soupEmitter) nextSlab() {}
+
+func (e *soupEmitter) cube(m *marcher, c *cube) {
+	m.polygonise(c)
+	asVertex := e.vertex[:0]
+
+	//Find the point of intersection of the surface with each edge
+	for iEdge := 0; iEdge < 12; iEdge++ {
+		var v Vector
+		if c.iEdgeFlags&(1<<uint(iEdge)) != 0 {
+			v = m.scale(m.edgeVertex(c, iEdge))
+		}
+		asVertex = append(asVertex, v)
+	}
+	for _, edges := range c.centers {
+		var sum Vector
+		for _, iEdge := range edges {
+			sum = AddVector(sum, asVertex[iEdge])
+		}
+		asVertex = append(asVertex, ScaleVector(sum, 1/float64(len(edges))))
+	}
+
+	//Draw the triangles that were found
+	for _, tr := range c.triangles {
+		e.t = append(e.t, facet(asVertex[tr[0]], asVertex[tr[1]], asVertex[tr[2]]))
+	}
+	e.vertex = asVertex
+}
+
+// meshEmitter builds an indexed mesh. The edge vertices are cached for the current slab,
+// so they are shared between all cubes adjacent to the edge.
+type meshEmitter struct {
+	m *Mesh
+	// nz1 is the number of grid nodes along Z.
+	nz1 int
+	// xEdges contains the vertex indices for the edges along X within the slab.
+	xEdges []int
+	// yzEdges contains the vertex indices for the edges along Y and Z
+	// on the two planes of the slab.
+	yzEdges [2][]int
+	// first keeps yzEdges of the first plane, if keepFirst is true.
+	// It's used to stitch the meshes built in parallel.
+	keepFirst bool
+	first     []int
+	// vertex is a buffer for the vertex indices of the current cube.
+	vertex []int
+}
+
+func newMeshEmitter(n [3]int) *meshEmitter {
+	e := &meshEmitter{
+		m:      new(Mesh),
+		nz1:    n[2] + 1,
+		xEdges: make([]int, (n[1]+1)*(n[2]+1)),
+		vertex: make([]int, 12),
+	}
+	for i := range e.yzEdges {
+		e.yzEdges[i] = make([]int, 2*(n[1]+1)*(n[2]+1))
+	}
+	resetEdges(e.xEdges)
+	resetEdges(e.yzEdges[0])
+	resetEdges(e.yzEdges[1])
+	return e
+}
+
+func resetEdges(edges []int) {
+	for i := range edges {
+		edges[i] = -1
+	}
+}
+
+func (e *meshEmitter) nextSlab() {
+	if e.keepFirst && e.first == nil {
+		e.first = e.yzEdges[0]
+		e.yzEdges[0] = make([]int, len(e.first))
+	}
+	e.yzEdges[0], e.yzEdges[1] = e.yzEdges[1], e.yzEdges[0]
+	resetEdges(e.yzEdges[1])
+	resetEdges(e.xEdges)
+}
+
+// edge returns the pointer to the cached vertex index of the edge of the cube.
+func (e *meshEmitter) edge(c *cube, iEdge int) *int {
+	a := a2iEdgeBase[iEdge]
+	ind := (c.y+a[1])*e.nz1 + c.z + a[2]
+	if axis := aiEdgeAxis[iEdge]; axis != 0 {
+		return &e.yzEdges[a[0]][2*ind+axis-1]
+	}
+	return &e.xEdges[ind]
+}
+
+func (e *meshEmitter) cube(m *marcher, c *cube) {
+	m.polygonise(c)
+	aiVertex := e.vertex[:12]
+	for iEdge := 0; iEdge < 12; iEdge++ {
+		if c.iEdgeFlags&(1<<uint(iEdge)) == 0 {
+			continue
+		}
+		cached := e.edge(c, iEdge)
+		if *cached < 0 {
+			v := m.edgeVertex(c, iEdge)
+			*cached = len(e.m.Vertex)
+			e.m.Vertex = append(e.m.Vertex, m.scale(v))
+			e.m.Normal = append(e.m.Normal, m.normal(c, iEdge))
+		}
+		aiVertex[iEdge] = *cached
+	}
+	for _, edges := range c.centers {
+		var v, nv Vector
+		for _, iEdge := range edges {
+			v = AddVector(v, e.m.Vertex[aiVertex[iEdge]])
+			nv = AddVector(nv, e.m.Normal[aiVertex[iEdge]])
+		}
+		aiVertex = append(aiVertex, len(e.m.Vertex))
+		e.m.Vertex = append(e.m.Vertex, ScaleVector(v, 1/float64(len(edges))))
+		e.m.Normal = append(e.m.Normal, normalizeVector(nv))
+	}
+
+	for _, tr := range c.triangles {
+		e.m.Triangle = append(e.m.Triangle, [3]int{aiVertex[tr[0]], aiVertex[tr[1]], aiVertex[tr[2]]})
+	}
+	e.vertex = aiVertex
+}
+
+// unitGrid returns the parameters of the grid with n cubes along each axis,
+// which covers the unit cube.
+func unitGrid(n int) (cubes [3]int, p0, step [3]float64) {
+	s := 1.0 / float64(n)
+	return [3]int{n, n, n}, [3]float64{}, [3]float64{s, s, s}
+}
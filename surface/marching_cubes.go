@@ -427,94 +427,13 @@ func vGetNormal(field g3.ScalarField, fX, fY, fZ float64) (rfNormal Vector) {
 	return
 }
 
-//vMarchingCubes iterates over the entire dataset, calling vMarchCube on each cube
+//MarchingCubes iterates over the entire dataset slab by slab. Every sample
+// of the field is taken once.
+// The solid is where the field is greater than the threshold. The triangles are
+// counterclockwise when viewed from the outside, and the facet normals follow
+// the winding, so they look outwards. If the field is greater outside of the solid,
+// negate it, or use MarchingCubesMesh and Mesh.Flip.
 func MarchingCubes(field g3.ScalarField, n int, threshold float64, size Vector) []stl.Triangle {
-	step := 1.0 / float64(n)
-	var t []stl.Triangle
-	for x := 0; x < n; x++ {
-		fx := float64(x) * step
-		for y := 0; y < n; y++ {
-			fy := float64(y) * step
-			for z := 0; z < n; z++ {
-				fz := float64(z) * step
-				t = marchCube(t, field, threshold, fx, fy, fz, step, size)
-			}
-		}
-	}
-	return t
-}
-
-//vMarchCube1 performs the Marching Cubes algorithm on a single cube
-func marchCube(t []stl.Triangle, field g3.ScalarField, threshold, fX, fY, fZ, fScale float64, size Vector) []stl.Triangle {
-	var iCorner, iVertex, iVertexTest, iEdge, iTriangle, iFlagIndex, iEdgeFlags int
-	var fOffset float64
-	var afCubeValue [8]float64
-	var asEdgeVertex [12]Vector
-	var asEdgeNorm [12]Vector
-
-	//Make a local copy of the values at the cube's corners
-	for iVertex = 0; iVertex < 8; iVertex++ {
-
-		afCubeValue[iVertex] = field(g3.Point{fX + a2fVertexOffset[iVertex][0]*fScale,
-			fY + a2fVertexOffset[iVertex][1]*fScale,
-			fZ + a2fVertexOffset[iVertex][2]*fScale})
-	}
-
-	//Find which vertices are inside of the surface and which are outside
-	iFlagIndex = 0
-	for iVertexTest = 0; iVertexTest < 8; iVertexTest++ {
-		if afCubeValue[iVertexTest] <= threshold {
-			iFlagIndex |= 1 << uint(iVertexTest)
-		}
-	}
-
-	//Find which edges are intersected by the surface
-	iEdgeFlags = aiCubeEdgeFlags[iFlagIndex]
-
-	//If the cube is entirely inside or outside of the surface, then there will be no intersections
-	if iEdgeFlags == 0 {
-		return t
-	}
-
-	//Find the point of intersection of the surface with each edge
-	//Then find the normal to the surface at those points
-	for iEdge = 0; iEdge < 12; iEdge++ {
-
-		//if there is an intersection on this edge
-		if iEdgeFlags&(1<<uint(iEdge)) != 0 {
-			fOffset = fGetOffset(afCubeValue[a2iEdgeConnection[iEdge][0]],
-				afCubeValue[a2iEdgeConnection[iEdge][1]], threshold)
-
-			asEdgeVertex[iEdge].X = fX + (a2fVertexOffset[a2iEdgeConnection[iEdge][0]][0]+fOffset*a2fEdgeDirection[iEdge][0])*fScale
-			asEdgeVertex[iEdge].Y = fY + (a2fVertexOffset[a2iEdgeConnection[iEdge][0]][1]+fOffset*a2fEdgeDirection[iEdge][1])*fScale
-			asEdgeVertex[iEdge].Z = fZ + (a2fVertexOffset[a2iEdgeConnection[iEdge][0]][2]+fOffset*a2fEdgeDirection[iEdge][2])*fScale
-
-			asEdgeNorm[iEdge] = vGetNormal(field, asEdgeVertex[iEdge].X, asEdgeVertex[iEdge].Y, asEdgeVertex[iEdge].Z)
-		}
-	}
-
-	//Draw the triangles that were found.  There can be up to five per cube
-	for iTriangle = 0; iTriangle < 5; iTriangle++ {
-		if a2iTriangleConnectionTable[iFlagIndex][3*iTriangle] < 0 {
-			break
-		}
-
-		var tt stl.Triangle
-		for iCorner = 0; iCorner < 3; iCorner++ {
-			iVertex = a2iTriangleConnectionTable[iFlagIndex][3*iTriangle+iCorner]
-
-			// This is actually being assigned 3 times to possibly different values.
-			// Find out what to do with this.
-			nv := Vector{size.X * asEdgeNorm[iVertex].X, size.Y * asEdgeNorm[iVertex].Y, size.Z * asEdgeNorm[iVertex].Z}
-			nv = normalizeVector(nv)
-			tt.N = stl.Point{nv.X, nv.Y, nv.Z}
-			tt.V[iCorner] = stl.Point{
-				size.X * asEdgeVertex[iVertex].X,
-				size.Y * asEdgeVertex[iVertex].Y,
-				size.Z * asEdgeVertex[iVertex].Z,
-			}
-		}
-		t = append(t, tt)
-	}
-	return t
+	cubes, p0, step := unitGrid(n)
+	return marchSoup(newMarcher(field, cubes, p0, step, threshold, size), 1)
 }
@@ -0,0 +1,192 @@
+package surface
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+)
+
+// marchingCubesReference is the original implementation of MarchingCubes,
+// which samples the field at every corner of every cube.
+func marchingCubesReference(field g3.ScalarField, n int, threshold float64, size Vector) []stl.Triangle {
+	step := 1.0 / float64(n)
+	var t []stl.Triangle
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			for z := 0; z < n; z++ {
+				t = marchCubeReference(t, field, threshold, x, y, z, step, size)
+			}
+		}
+	}
+	return t
+}
+
+// marchCubeReference performs the Marching Cubes algorithm on the cube (x, y, z).
+//
+// The only change from the original is that the corners are sampled at (x+1)*fScale
+// and not at x*fScale+fScale. These differ in the last bit, so the original sampled
+// the same grid node at slightly different points from the adjacent cubes.
+func marchCubeReference(t []stl.Triangle, field g3.ScalarField, threshold float64, x, y, z int, fScale float64, size Vector) []stl.Triangle {
+	var iCorner, iVertex, iVertexTest, iEdge, iTriangle, iFlagIndex, iEdgeFlags int
+	var fOffset float64
+	var afCubeValue [8]float64
+	var asEdgeVertex [12]Vector
+	var asEdgeNorm [12]Vector
+	fX, fY, fZ := float64(x)*fScale, float64(y)*fScale, float64(z)*fScale
+
+	//Make a local copy of the values at the cube's corners
+	for iVertex = 0; iVertex < 8; iVertex++ {
+
+		o := a2iVertexOffset[iVertex]
+		afCubeValue[iVertex] = field(g3.Point{float64(x+o[0]) * fScale, float64(y+o[1]) * fScale, float64(z+o[2]) * fScale})
+	}
+
+	//Find which vertices are inside of the surface and which are outside
+	iFlagIndex = 0
+	for iVertexTest = 0; iVertexTest < 8; iVertexTest++ {
+		if afCubeValue[iVertexTest] <= threshold {
+			iFlagIndex |= 1 << uint(iVertexTest)
+		}
+	}
+
+	//Find which edges are intersected by the surface
+	iEdgeFlags = aiCubeEdgeFlags[iFlagIndex]
+
+	//If the cube is entirely inside or outside of the surface, then there will be no intersections
+	if iEdgeFlags == 0 {
+		return t
+	}
+
+	//Find the point of intersection of the surface with each edge
+	//Then find the normal to the surface at those points
+	for iEdge = 0; iEdge < 12; iEdge++ {
+
+		//if there is an intersection on this edge
+		if iEdgeFlags&(1<<uint(iEdge)) != 0 {
+			fOffset = fGetOffset(afCubeValue[a2iEdgeConnection[iEdge][0]],
+				afCubeValue[a2iEdgeConnection[iEdge][1]], threshold)
+
+			asEdgeVertex[iEdge].X = fX + (a2fVertexOffset[a2iEdgeConnection[iEdge][0]][0]+fOffset*a2fEdgeDirection[iEdge][0])*fScale
+			asEdgeVertex[iEdge].Y = fY + (a2fVertexOffset[a2iEdgeConnection[iEdge][0]][1]+fOffset*a2fEdgeDirection[iEdge][1])*fScale
+			asEdgeVertex[iEdge].Z = fZ + (a2fVertexOffset[a2iEdgeConnection[iEdge][0]][2]+fOffset*a2fEdgeDirection[iEdge][2])*fScale
+
+			asEdgeNorm[iEdge] = vGetNormal(field, asEdgeVertex[iEdge].X, asEdgeVertex[iEdge].Y, asEdgeVertex[iEdge].Z)
+		}
+	}
+
+	//Draw the triangles that were found.  There can be up to five per cube
+	for iTriangle = 0; iTriangle < 5; iTriangle++ {
+		if a2iTriangleConnectionTable[iFlagIndex][3*iTriangle] < 0 {
+			break
+		}
+
+		var tt stl.Triangle
+		for iCorner = 0; iCorner < 3; iCorner++ {
+			iVertex = a2iTriangleConnectionTable[iFlagIndex][3*iTriangle+iCorner]
+
+			// This is actually being assigned 3 times to possibly different values.
+			// Find out what to do with this.
+			nv := Vector{size.X * asEdgeNorm[iVertex].X, size.Y * asEdgeNorm[iVertex].Y, size.Z * asEdgeNorm[iVertex].Z}
+			nv = normalizeVector(nv)
+			tt.N = stl.Point{nv.X, nv.Y, nv.Z}
+			tt.V[iCorner] = stl.Point{
+				size.X * asEdgeVertex[iVertex].X,
+				size.Y * asEdgeVertex[iVertex].Y,
+				size.Z * asEdgeVertex[iVertex].Z,
+			}
+		}
+		t = append(t, tt)
+	}
+	return t
+}
+
+func TestMarchingCubes(t *testing.T) {
+	const n = 24
+	size := Vector{2, 3, 4}
+	var calls int
+	field := func(p g3.Point) float64 {
+		calls++
+		return sphereField(p)
+	}
+	got := MarchingCubes(field, n, 0, size)
+	if want := (n + 1) * (n + 1) * (n + 1); calls != want {
+		t.Errorf("the field is sampled %d times, want %d", calls, want)
+	}
+
+	want := marchingCubesReference(sphereField, n, 0, size)
+	gotV, wantV := make([][3]stl.Point, len(got)), make([][3]stl.Point, len(want))
+	for i := range got {
+		gotV[i] = got[i].V
+		checkFacet(t, got[i], Vector{0.5 * size.X, 0.45 * size.Y, 0.52 * size.Z})
+	}
+	for i := range want {
+		wantV[i] = want[i].V
+	}
+	if !reflect.DeepEqual(gotV, wantV) {
+		t.Errorf("the vertices differ from the ones of the reference")
+	}
+
+	// The normals of the mesh are taken from the grid samples, so they only approximate
+	// the ones the reference probes from the field.
+	calls = 0
+	m := MarchingCubesMesh(field, n, 0, Vector{1, 1, 1})
+	if want := (n + 1) * (n + 1) * (n + 1); calls != want {
+		t.Errorf("MarchingCubesMesh samples the field %d times, want %d", calls, want)
+	}
+	for i, v := range m.Vertex {
+		want := normalizeVector(vGetNormal(sphereField, v.X, v.Y, v.Z))
+		if dot := DotProduct(m.Normal[i], want); dot < 0.99 {
+			t.Fatalf("vertex %v: normal %v is too far from %v of vGetNormal", v, m.Normal[i], want)
+		}
+	}
+}
+
+// checkFacet verifies that the facet normal follows the winding
+// and looks away from the center of the sphere.
+func checkFacet(t *testing.T, tr stl.Triangle, center Vector) {
+	var v [3]Vector
+	for j, p := range tr.V {
+		v[j] = Vector{float64(p[0]), float64(p[1]), float64(p[2])}
+	}
+	nv := Vector{float64(tr.N[0]), float64(tr.N[1]), float64(tr.N[2])}
+	if dot := DotProduct(nv, normalizeVector(CrossProduct(SubVector(v[1], v[0]), SubVector(v[2], v[0])))); dot < 0.999 {
+		t.Fatalf("triangle %v: normal %v does not match the winding", tr.V, tr.N)
+	}
+	if DotProduct(nv, SubVector(v[0], center)) <= 0 {
+		t.Fatalf("triangle %v: normal %v looks inwards", tr.V, tr.N)
+	}
+}
+
+func TestMarchingCubesOrientation(t *testing.T) {
+	const n = 16
+	// The mirrored scale must not turn the surface inside out.
+	size := Vector{-2, 3, 4}
+	center := Vector{0.5 * size.X, 0.45 * size.Y, 0.52 * size.Z}
+	for _, tr := range MarchingCubes(sphereField, n, 0, size) {
+		checkFacet(t, tr, center)
+	}
+	for _, tr := range MarchingTetrahedra(sphereField, n, 0, size) {
+		checkFacet(t, tr, center)
+	}
+	m := MarchingCubesMesh(sphereField, n, 0, size)
+	if vol := signedVolume(m); vol <= 0 {
+		t.Errorf("signed volume: want positive, got %f", vol)
+	}
+
+	// The vertex normals of a stretched sphere are the normals of the ellipsoid,
+	// not the stretched normals of the sphere.
+	for i, v := range m.Vertex {
+		p := SubVector(v, center)
+		want := normalizeVector(Vector{p.X / (size.X * size.X), p.Y / (size.Y * size.Y), p.Z / (size.Z * size.Z)})
+		if dot := DotProduct(m.Normal[i], want); dot < 0.99 {
+			t.Fatalf("vertex %v: normal %v is too far from %v", v, m.Normal[i], want)
+		}
+	}
+
+	m.Flip()
+	if vol := signedVolume(m); vol >= 0 {
+		t.Errorf("signed volume of the flipped mesh: want negative, got %f", vol)
+	}
+}
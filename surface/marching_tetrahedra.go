@@ -0,0 +1,107 @@
+package surface
+
+import (
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+)
+
+// MarchingTetrahedra iterates over the entire dataset like MarchingCubes,
+// but splits every cube into six tetrahedra around its main diagonal.
+// Unlike the cube tables, the tetrahedron tables have no ambiguous cases,
+// so the resulting surface has no cracks. The price is about twice as many triangles.
+// The orientation of the triangles is the same as in MarchingCubes.
+func MarchingTetrahedra(field g3.ScalarField, n int, threshold float64, size Vector) []stl.Triangle {
+	step := 1.0 / float64(n)
+	var t []stl.Triangle
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			for z := 0; z < n; z++ {
+				t = marchCubeTetrahedra(t, field, threshold, x, y, z, step, size)
+			}
+		}
+	}
+	return t
+}
+
+// marchCubeTetrahedra performs the Marching Tetrahedra algorithm on the cube (x, y, z) of the grid with the given step.
+// The corners are sampled at the grid nodes like in the marcher, so the adjacent cubes share the samples exactly.
+func marchCubeTetrahedra(t []stl.Triangle, field g3.ScalarField, threshold float64, x, y, z int, step float64, size Vector) []stl.Triangle {
+	var asCubePosition [8]Vector
+	var afCubeValue [8]float64
+	var asTetrahedronPosition [4]Vector
+	var afTetrahedronValue [4]float64
+
+	//Make a local copy of the cube's corner positions and values
+	for iVertex := 0; iVertex < 8; iVertex++ {
+		o := a2iVertexOffset[iVertex]
+		asCubePosition[iVertex] = Vector{
+			float64(x+o[0]) * step,
+			float64(y+o[1]) * step,
+			float64(z+o[2]) * step,
+		}
+		afCubeValue[iVertex] = field(g3.Point{asCubePosition[iVertex].X, asCubePosition[iVertex].Y, asCubePosition[iVertex].Z})
+	}
+
+	for iTetrahedron := 0; iTetrahedron < 6; iTetrahedron++ {
+		for iVertex := 0; iVertex < 4; iVertex++ {
+			iVertexInACube := a2iTetrahedronsInACube[iTetrahedron][iVertex]
+			asTetrahedronPosition[iVertex] = asCubePosition[iVertexInACube]
+			afTetrahedronValue[iVertex] = afCubeValue[iVertexInACube]
+		}
+		t = marchTetrahedron(t, threshold, &asTetrahedronPosition, &afTetrahedronValue, size)
+	}
+	return t
+}
+
+// marchTetrahedron performs the Marching Tetrahedra algorithm on a single tetrahedron
+func marchTetrahedron(t []stl.Triangle, threshold float64, pasTetrahedronPosition *[4]Vector, pafTetrahedronValue *[4]float64, size Vector) []stl.Triangle {
+	var asEdgeVertex [6]Vector
+
+	//Find which vertices are inside of the surface and which are outside
+	iFlagIndex := 0
+	for iVertex := 0; iVertex < 4; iVertex++ {
+		if pafTetrahedronValue[iVertex] <= threshold {
+			iFlagIndex |= 1 << uint(iVertex)
+		}
+	}
+
+	//Find which edges are intersected by the surface
+	iEdgeFlags := aiTetrahedronEdgeFlags[iFlagIndex]
+
+	//If the tetrahedron is entirely inside or outside of the surface, then there will be no intersections
+	if iEdgeFlags == 0 {
+		return t
+	}
+
+	//Find the point of intersection of the surface with each edge
+	for iEdge := 0; iEdge < 6; iEdge++ {
+		if iEdgeFlags&(1<<uint(iEdge)) == 0 {
+			continue
+		}
+		iVert0 := a2iTetrahedronEdgeConnection[iEdge][0]
+		iVert1 := a2iTetrahedronEdgeConnection[iEdge][1]
+		fOffset := fGetOffset(pafTetrahedronValue[iVert0], pafTetrahedronValue[iVert1], threshold)
+		fInvOffset := 1.0 - fOffset
+
+		p0, p1 := pasTetrahedronPosition[iVert0], pasTetrahedronPosition[iVert1]
+		asEdgeVertex[iEdge].X = size.X * (fInvOffset*p0.X + fOffset*p1.X)
+		asEdgeVertex[iEdge].Y = size.Y * (fInvOffset*p0.Y + fOffset*p1.Y)
+		asEdgeVertex[iEdge].Z = size.Z * (fInvOffset*p0.Z + fOffset*p1.Z)
+	}
+
+	//Draw the triangles that were found.  There can be up to 2 per tetrahedron
+	//The facet normals follow the winding, which is counterclockwise when viewed from the outside
+	for iTriangle := 0; iTriangle < 2; iTriangle++ {
+		if a2iTetrahedronTriangles[iFlagIndex][3*iTriangle] < 0 {
+			break
+		}
+
+		row := a2iTetrahedronTriangles[iFlagIndex][3*iTriangle:]
+		i1, i2 := row[1], row[2]
+		if size.X*size.Y*size.Z < 0 {
+			i1, i2 = i2, i1
+		}
+		t = append(t, facet(asEdgeVertex[row[0]], asEdgeVertex[i1], asEdgeVertex[i2]))
+	}
+	return t
+}
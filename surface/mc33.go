@@ -0,0 +1,523 @@
+package surface
+
+import (
+	"math"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+)
+
+// This file contains a topologically correct variant of Marching Cubes in the spirit
+// of Marching Cubes 33 by E. Chernyaev, see also T. Lewiner et al,
+// "Efficient implementation of Marching Cubes' cases with topological guarantees".
+//
+// Instead of looking up the triangles in a table, the surface of every cube is built
+// from its contours on the faces of the cube. The ambiguous faces, where the diagonal
+// corners have the same sign, are resolved with the asymptotic decider. It depends only
+// on the four values at the face, so the adjacent cubes always agree about the contour
+// on the shared face, and the surface has no holes. The contours on the faces form closed
+// loops on the boundary of the cube. Usually, every loop bounds its own disk, but
+// the trilinear interpolant can also connect two loops with a tunnel through the cube.
+// The interior test looks at the critical points of the interpolant to find such tunnels.
+
+// MarchingCubes33 is like MarchingCubes, but the surface has the topology
+// of the trilinear interpolation of the samples. In particular, the ambiguous
+// cubes don't produce holes, so the surface is a closed manifold if the field
+// is not greater than the threshold on the boundary of the grid.
+func MarchingCubes33(field g3.ScalarField, n int, threshold float64, size Vector) []stl.Triangle {
+	cubes, p0, step := unitGrid(n)
+	m := newMarcher(field, cubes, p0, step, threshold, size)
+	m.mc33 = true
+	return marchSoup(m, 1)
+}
+
+// MarchingCubes33Mesh is like MarchingCubes33, but it returns an indexed mesh.
+func MarchingCubes33Mesh(field g3.ScalarField, n int, threshold float64, size Vector) *Mesh {
+	cubes, p0, step := unitGrid(n)
+	m := newMarcher(field, cubes, p0, step, threshold, size)
+	m.mc33 = true
+	return marchMesh(m, 1)
+}
+
+// cornerAt maps the offset of the corner of the cube to its number.
+var cornerAt [2][2][2]int
+
+// faceCorners lists the corners of each face counterclockwise when viewed from the outside.
+// The face 2*k+s is perpendicular to the axis k and has the coordinate s along it.
+var faceCorners [6][4]int
+
+// faceEdges lists the edges of each face. The edge faceEdges[f][i] connects
+// the corners faceCorners[f][i] and faceCorners[f][i+1].
+var faceEdges [6][4]int
+
+// faceGrid lists the corners of each face in the order (0, 0), (0, 1), (1, 0), (1, 1)
+// of their coordinates along the other two axes taken in the ascending order.
+// Unlike faceCorners, it's the same for both cubes adjacent to the face,
+// so they make the same decisions about the face bit by bit.
+var faceGrid [6][4]int
+
+// faceAxes lists the other two axes of each face in the ascending order.
+var faceAxes [6][2]int
+
+// edgeFaces lists the two faces adjacent to each edge.
+var edgeFaces [12][2]int
+
+func init() {
+	for i, o := range a2iVertexOffset {
+		cornerAt[o[0]][o[1]][o[2]] = i
+	}
+	// ccw is the counterclockwise order of the corners of the square
+	// with the axes k+1 and k+2, when viewed from the end of the axis k.
+	ccw := [4][2]int{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+	var edgeFaceCount [12]int
+	for k := 0; k < 3; k++ {
+		u, v := (k+1)%3, (k+2)%3
+		lo, hi := u, v
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		for s := 0; s < 2; s++ {
+			f := 2*k + s
+			faceAxes[f] = [2]int{lo, hi}
+			for i := range ccw {
+				uv := ccw[i]
+				if s == 0 {
+					uv = ccw[3-i]
+				}
+				var o [3]int
+				o[k], o[u], o[v] = s, uv[0], uv[1]
+				faceCorners[f][i] = cornerAt[o[0]][o[1]][o[2]]
+			}
+			for i := 0; i < 4; i++ {
+				var o [3]int
+				o[k], o[lo], o[hi] = s, i>>1, i&1
+				faceGrid[f][i] = cornerAt[o[0]][o[1]][o[2]]
+			}
+			for i := 0; i < 4; i++ {
+				a, b := faceCorners[f][i], faceCorners[f][(i+1)%4]
+				for iEdge, e := range a2iEdgeConnection {
+					if e[0] == a && e[1] == b || e[0] == b && e[1] == a {
+						faceEdges[f][i] = iEdge
+						edgeFaces[iEdge][edgeFaceCount[iEdge]] = f
+						edgeFaceCount[iEdge]++
+					}
+				}
+			}
+		}
+	}
+}
+
+// cube33 keeps the state of polygonise33 for a single cube.
+type cube33 struct {
+	// w contains the values at the corners relative to the threshold.
+	w     [8]float64
+	solid [8]bool
+	// parent is a union-find forest over the corners. Two corners are in the same tree
+	// if they are connected over the boundary of the cube within their sign.
+	parent [8]int
+	// ambiguous is true for the faces where the diagonal corners have the same sign.
+	ambiguous [6]bool
+	// joinSolid is true if the asymptotic decider connects the solid corners of an ambiguous face.
+	joinSolid [6]bool
+	// pos contains the positions of the vertices of the cube within the unit cube,
+	// including the centers.
+	pos [24]Vector
+}
+
+func (q *cube33) find(i int) int {
+	for q.parent[i] != i {
+		i = q.parent[i]
+	}
+	return i
+}
+
+func (q *cube33) union(i, j int) {
+	q.parent[q.find(i)] = q.find(j)
+}
+
+// faceSaddle returns the value of the bilinear interpolant of the face at its saddle point.
+// It must only be called for the ambiguous faces.
+func (q *cube33) faceSaddle(f int) float64 {
+	g := &faceGrid[f]
+	w00, w01, w10, w11 := q.w[g[0]], q.w[g[1]], q.w[g[2]], q.w[g[3]]
+	return (w00*w11 - w01*w10) / (w00 + w11 - w01 - w10)
+}
+
+// faceRegion returns the region of the boundary of the cube, which contains the projection
+// of p to the face f, provided that the interpolant there has the sign solid.
+// It returns -1 if the face has no corners of that sign.
+func (q *cube33) faceRegion(f int, p [3]float64, solid bool) int {
+	g := &faceGrid[f]
+	if q.ambiguous[f] && q.joinSolid[f] != solid {
+		// The corners of this sign are separated by the saddle point, and the point
+		// lies in the same quadrant around the saddle point as its corner.
+		w00, w01, w10, w11 := q.w[g[0]], q.w[g[1]], q.w[g[2]], q.w[g[3]]
+		d := w00 - w01 - w10 + w11
+		var i int
+		if p[faceAxes[f][0]] > (w00-w01)/d {
+			i += 2
+		}
+		if p[faceAxes[f][1]] > (w00-w10)/d {
+			i++
+		}
+		if q.solid[g[i]] == solid {
+			return q.find(g[i])
+		}
+		return -1
+	}
+	for _, c := range g {
+		if q.solid[c] == solid {
+			return q.find(c)
+		}
+	}
+	return -1
+}
+
+// polygonise33 fills c.triangles and c.centers with the surface of the cube,
+// which has the topology of the trilinear interpolant.
+func polygonise33(c *cube, threshold float64) {
+	var q cube33
+	for i := range q.w {
+		q.w[i] = c.afValue[i] - threshold
+		q.solid[i] = c.iFlagIndex&(1<<uint(i)) == 0
+		q.parent[i] = i
+	}
+	for iEdge, e := range a2iEdgeConnection {
+		if q.solid[e[0]] == q.solid[e[1]] {
+			q.union(e[0], e[1])
+			continue
+		}
+		fOffset := fGetOffset(q.w[e[0]], q.w[e[1]], 0)
+		o0 := a2fVertexOffset[e[0]]
+		q.pos[iEdge] = Vector{
+			o0[0] + fOffset*a2fEdgeDirection[iEdge][0],
+			o0[1] + fOffset*a2fEdgeDirection[iEdge][1],
+			o0[2] + fOffset*a2fEdgeDirection[iEdge][2],
+		}
+	}
+
+	// Resolve the ambiguous faces with the asymptotic decider.
+	for f := range faceCorners {
+		fc := &faceCorners[f]
+		if q.solid[fc[0]] != q.solid[fc[2]] || q.solid[fc[1]] != q.solid[fc[3]] || q.solid[fc[0]] == q.solid[fc[1]] {
+			continue
+		}
+		q.ambiguous[f] = true
+		q.joinSolid[f] = q.faceSaddle(f) > 0
+		if q.solid[fc[0]] == q.joinSolid[f] {
+			q.union(fc[0], fc[2])
+		} else {
+			q.union(fc[1], fc[3])
+		}
+	}
+
+	// Find the contours on the faces. The segments are directed so that
+	// the solid is on the right when viewed from the outside of the cube.
+	// next maps the edge to the next edge of its loop.
+	var next [12]int
+	for i := range next {
+		next[i] = -1
+	}
+	link := func(from, to int, arcSolid bool) {
+		// The corners passed counterclockwise from the edge from to the edge to
+		// are on the right of the segment.
+		if !arcSolid {
+			from, to = to, from
+		}
+		next[from] = to
+	}
+	for f := range faceCorners {
+		fc, fe := &faceCorners[f], &faceEdges[f]
+		if q.ambiguous[f] {
+			// Cut off the corners, which are not connected across the face.
+			for t := 0; t < 4; t++ {
+				if q.solid[fc[t]] != q.joinSolid[f] {
+					link(fe[(t+3)%4], fe[t], q.solid[fc[t]])
+				}
+			}
+			continue
+		}
+		i0, i1 := -1, -1
+		for i := 0; i < 4; i++ {
+			if q.solid[fc[i]] != q.solid[fc[(i+1)%4]] {
+				if i0 < 0 {
+					i0 = i
+				} else {
+					i1 = i
+				}
+			}
+		}
+		if i0 >= 0 {
+			link(fe[i0], fe[i1], q.solid[fc[i0+1]])
+		}
+	}
+
+	// Join the segments into loops.
+	var buf [12]int
+	var loops [][]int
+	var loopOf [12]int
+	for i := range loopOf {
+		loopOf[i] = -1
+	}
+	var n int
+	for iEdge := range next {
+		if next[iEdge] < 0 || loopOf[iEdge] >= 0 {
+			continue
+		}
+		start := n
+		for e := iEdge; loopOf[e] < 0; e = next[e] {
+			loopOf[e] = len(loops)
+			buf[n] = e
+			n++
+		}
+		loops = append(loops, buf[start:n])
+	}
+
+	tube := [2]int{-1, -1}
+	if len(loops) > 1 {
+		tube = q.interiorTest(loops)
+	}
+	for l, loop := range loops {
+		switch l {
+		case tube[0]:
+			q.tube(c, loop, loops[tube[1]])
+		case tube[1]:
+		default:
+			q.disk(c, loop)
+		}
+	}
+}
+
+// loopRegions returns the regions of the boundary of the cube on the solid
+// and on the empty side of the loop.
+func (q *cube33) loopRegions(loop []int) (solid, empty int) {
+	e := a2iEdgeConnection[loop[0]]
+	if q.solid[e[0]] {
+		return q.find(e[0]), q.find(e[1])
+	}
+	return q.find(e[1]), q.find(e[0])
+}
+
+// interiorTest returns the pair of loops connected with a tunnel through the cube,
+// or -1, -1 if every loop bounds its own disk.
+//
+// The interpolant is linear along the lines parallel to the axes, so it's constant along
+// the three lines through its critical point. If the critical point is inside the cube,
+// it connects the six points on the faces of the cube, which have the same sign. Two regions
+// of the boundary, which are disconnected on the boundary, but connected through the cube,
+// mean a tunnel between the loops which separate them from the region of the opposite sign
+// in between.
+func (q *cube33) interiorTest(loops [][]int) [2]int {
+	for _, p := range trilinearCritical(&q.w) {
+		if p[0] <= 0 || p[0] >= 1 || p[1] <= 0 || p[1] >= 1 || p[2] <= 0 || p[2] >= 1 {
+			continue
+		}
+		v := trilinear(&q.w, p)
+		if v == 0 {
+			continue
+		}
+		solid := v > 0
+		r0 := -1
+		for f := range faceCorners {
+			r := q.faceRegion(f, p, solid)
+			if r < 0 || r == r0 {
+				continue
+			}
+			if r0 < 0 {
+				r0 = r
+				continue
+			}
+			for la, a := range loops {
+				as, ae := q.loopRegions(a)
+				for lb, b := range loops {
+					bs, be := q.loopRegions(b)
+					if solid && as == r0 && bs == r && ae == be || !solid && ae == r0 && be == r && as == bs {
+						return [2]int{la, lb}
+					}
+				}
+			}
+		}
+	}
+	return [2]int{-1, -1}
+}
+
+// disk triangulates the loop. If possible, it's a fan from one of the vertices of the loop.
+// The diagonals of the fan must not connect the vertices on the same face of the cube,
+// because the adjacent cube could have the same diagonal. Otherwise, the fan is built
+// around the center of the loop.
+func (q *cube33) disk(c *cube, loop []int) {
+	n := len(loop)
+	for i := 0; i < n; i++ {
+		if !q.fanAllowed(loop, i) {
+			continue
+		}
+		for j := 1; j+1 < n; j++ {
+			c.triangles = append(c.triangles, [3]int{loop[i], loop[(i+j)%n], loop[(i+j+1)%n]})
+		}
+		return
+	}
+	center := q.center(c, loop)
+	for i := 0; i < n; i++ {
+		c.triangles = append(c.triangles, [3]int{center, loop[i], loop[(i+1)%n]})
+	}
+}
+
+func (q *cube33) fanAllowed(loop []int, apex int) bool {
+	n := len(loop)
+	for j := 2; j+1 < n; j++ {
+		if shareFace(loop[apex], loop[(apex+j)%n]) {
+			return false
+		}
+	}
+	return true
+}
+
+func shareFace(e1, e2 int) bool {
+	for _, f1 := range edgeFaces[e1] {
+		for _, f2 := range edgeFaces[e2] {
+			if f1 == f2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// center adds the vertex at the average of the vertices on the edges and returns its number.
+func (q *cube33) center(c *cube, edges []int) int {
+	id := 12 + len(c.centers)
+	c.centers = append(c.centers, append([]int(nil), edges...))
+	var sum Vector
+	for _, e := range edges {
+		sum = AddVector(sum, q.pos[e])
+	}
+	q.pos[id] = ScaleVector(sum, 1/float64(len(edges)))
+	return id
+}
+
+// tube connects the loops a and b with a tunnel. The rungs between the loops
+// could lie on the faces of the cube and clash with the adjacent cube, so the tube
+// goes through a ring of three vertices inside the cube, and all rungs end inside.
+// Each vertex of the ring is the center of a third of a and the matching third of b.
+func (q *cube33) tube(c *cube, a, b []int) {
+	// The loops go in the opposite directions around the tunnel.
+	// Walk b backwards, starting from the vertex nearest to a[0].
+	na, nb := len(a), len(b)
+	k0 := q.nearest(b, a[0])
+	back := make([]int, nb)
+	for j := range back {
+		back[j] = b[((k0-j)%nb+nb)%nb]
+	}
+	var ring [3]int
+	for i := range ring {
+		edges := append(append([]int(nil), a[na*i/3:na*(i+1)/3]...), back[nb*i/3:nb*(i+1)/3]...)
+		ring[i] = q.center(c, edges)
+	}
+	// The ring goes like a in one strip and like b in the other one.
+	q.strip(c, a, []int{ring[2], ring[1], ring[0]})
+	q.strip(c, ring[:], b)
+}
+
+// nearest returns the index of the vertex of the loop nearest to the vertex v.
+func (q *cube33) nearest(loop []int, v int) (k int) {
+	for i, u := range loop {
+		if distance(q.pos[v], q.pos[u]) < distance(q.pos[v], q.pos[loop[k]]) {
+			k = i
+		}
+	}
+	return
+}
+
+// strip connects the loops a and b, which go in the opposite directions, with a strip
+// of triangles. At every step, the strip advances along the loop, which makes the shorter rung.
+//
+// The rung between a[i] and bb(j) must not be made twice, so the walk is restricted:
+// it starts with a step along a and a step along b, and it doesn't finish b before
+// the rung a[1]-bb(0) is left behind.
+func (q *cube33) strip(c *cube, a, b []int) {
+	na, nb := len(a), len(b)
+	k0 := q.nearest(b, a[0])
+	// bb returns the j-th vertex of b walked backwards starting from b[k0].
+	bb := func(j int) int {
+		return b[((k0-j)%nb+nb)%nb]
+	}
+	var i, j int
+	for step := 0; i < na || j < nb; step++ {
+		canA := i < na
+		canB := j < nb && (j+1 < nb || i >= 2)
+		if step == 0 || step > 1 && canA && (!canB || distance(q.pos[a[(i+1)%na]], q.pos[bb(j)]) <= distance(q.pos[a[i%na]], q.pos[bb(j+1)])) {
+			c.triangles = append(c.triangles, [3]int{a[i%na], a[(i+1)%na], bb(j)})
+			i++
+		} else {
+			c.triangles = append(c.triangles, [3]int{bb(j + 1), bb(j), a[i%na]})
+			j++
+		}
+	}
+}
+
+func distance(a, b Vector) float64 {
+	d := SubVector(a, b)
+	return math.Sqrt(DotProduct(d, d))
+}
+
+// trilinear returns the value of the trilinear interpolation of the corner values at p.
+func trilinear(w *[8]float64, p [3]float64) (res float64) {
+	for i, o := range a2iVertexOffset {
+		k := w[i]
+		for axis := 0; axis < 3; axis++ {
+			if o[axis] == 0 {
+				k *= 1 - p[axis]
+			} else {
+				k *= p[axis]
+			}
+		}
+		res += k
+	}
+	return
+}
+
+// trilinearCritical returns the critical points of the trilinear interpolation
+// of the corner values. There are at most two of them, unless the interpolant is degenerate,
+// in which case nothing is returned.
+func trilinearCritical(w *[8]float64) [][3]float64 {
+	at := func(x, y, z int) float64 {
+		return w[cornerAt[x][y][z]]
+	}
+	// F = a + bx + cy + dz + exy + fxz + gyz + hxyz
+	a := at(0, 0, 0)
+	b := at(1, 0, 0) - a
+	c := at(0, 1, 0) - a
+	d := at(0, 0, 1) - a
+	e := at(1, 1, 0) - at(1, 0, 0) - at(0, 1, 0) + a
+	f := at(1, 0, 1) - at(1, 0, 0) - at(0, 0, 1) + a
+	g := at(0, 1, 1) - at(0, 1, 0) - at(0, 0, 1) + a
+	h := at(1, 1, 1) - at(1, 1, 0) - at(1, 0, 1) - at(0, 1, 1) + at(1, 0, 0) + at(0, 1, 0) + at(0, 0, 1) - a
+
+	if h == 0 {
+		// The gradient is linear, and there is a single critical point.
+		if e == 0 || f == 0 || g == 0 {
+			return nil
+		}
+		z := (d*e - c*f - b*g) / (2 * f * g)
+		return [][3]float64{{-(c + g*z) / e, -(b + f*z) / e, z}}
+	}
+
+	// In the coordinates X, Y, Z relative to (x0, y0, z0), F = hXYZ + αX + βY + γZ + δ.
+	x0, y0, z0 := -g/h, -f/h, -e/h
+	alpha := b + e*y0 + f*z0 + h*y0*z0
+	beta := c + e*x0 + g*z0 + h*x0*z0
+	gamma := d + f*x0 + g*y0 + h*x0*y0
+	if alpha == 0 || beta == 0 || gamma == 0 {
+		return nil
+	}
+	// The gradient is zero where YZ = -α/h, XZ = -β/h and XY = -γ/h.
+	p2 := -alpha * beta * gamma / (h * h * h)
+	if p2 <= 0 {
+		return nil
+	}
+	var res [][3]float64
+	for _, p := range []float64{math.Sqrt(p2), -math.Sqrt(p2)} {
+		res = append(res, [3]float64{x0 - h*p/alpha, y0 - h*p/beta, z0 - h*p/gamma})
+	}
+	return res
+}
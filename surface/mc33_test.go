@@ -0,0 +1,192 @@
+package surface
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// checkManifold verifies that the triangles around every vertex form a single fan,
+// which goes around the vertex.
+func checkManifold(t *testing.T, m *Mesh) {
+	link := make([]map[int]int, len(m.Vertex))
+	for _, tr := range m.Triangle {
+		for i := 0; i < 3; i++ {
+			v, a, b := tr[i], tr[(i+1)%3], tr[(i+2)%3]
+			if link[v] == nil {
+				link[v] = make(map[int]int)
+			}
+			if _, ok := link[v][a]; ok {
+				t.Fatalf("vertex %d: the edge %d-%d is used twice", v, v, a)
+			}
+			link[v][a] = b
+		}
+	}
+	for v, next := range link {
+		if next == nil {
+			continue
+		}
+		var start, cnt int
+		for a := range next {
+			start = a
+			break
+		}
+		for a := start; ; {
+			a = next[a]
+			cnt++
+			if a == start {
+				break
+			}
+			if cnt > len(next) {
+				t.Fatalf("vertex %d: the triangles around the vertex don't form a fan", v)
+			}
+		}
+		if cnt != len(next) {
+			t.Fatalf("vertex %d: the triangles form %d fans, want one", v, cnt)
+		}
+	}
+}
+
+// march33 runs Marching Cubes 33 over the values given for the nodes of the grid.
+func march33(n [3]int, node func(x, y, z int) float64) *Mesh {
+	step := [3]float64{1 / float64(n[0]), 1 / float64(n[1]), 1 / float64(n[2])}
+	m := newMarcher(nil, n, [3]float64{}, step, 0, Vector{1, 1, 1})
+	m.node = node
+	m.mc33 = true
+	return marchMesh(m, 1)
+}
+
+func checkSolid(t *testing.T, m *Mesh) {
+	checkClosed(t, m)
+	checkManifold(t, m)
+	if vol := signedVolume(m); vol <= 0 {
+		t.Fatalf("signed volume: want positive, got %f", vol)
+	}
+}
+
+func TestMarchingCubes33Cases(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for iFlagIndex := 1; iFlagIndex < 255; iFlagIndex++ {
+		// The values at the corners are random, so both outcomes
+		// of the asymptotic decider and of the interior test are seen.
+		for trial := 0; trial < 100; trial++ {
+			var w [8]float64
+			for i := range w {
+				w[i] = 0.05 + r.Float64()
+				if iFlagIndex&(1<<uint(i)) == 0 {
+					w[i] = -w[i]
+				}
+			}
+			// The cube is surrounded by empty space, so the surface must be closed.
+			m := march33([3]int{3, 3, 3}, func(x, y, z int) float64 {
+				if x < 1 || x > 2 || y < 1 || y > 2 || z < 1 || z > 2 {
+					return -1
+				}
+				// The flags of the cube are set for the empty corners.
+				return -w[cornerAt[x-1][y-1][z-1]]
+			})
+			if t.Failed() {
+				return
+			}
+			checkSolid(t, m)
+			if t.Failed() {
+				t.Fatalf("case %#02x, values %v", iFlagIndex, w)
+			}
+		}
+	}
+}
+
+func TestMarchingCubes33Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const n = 8
+	for trial := 0; trial < 200; trial++ {
+		var values [n + 1][n + 1][n + 1]float64
+		for x := 1; x < n; x++ {
+			for y := 1; y < n; y++ {
+				for z := 1; z < n; z++ {
+					values[x][y][z] = r.Float64()*2 - 1
+				}
+			}
+		}
+		m := march33([3]int{n, n, n}, func(x, y, z int) float64 {
+			if x < 0 || x > n || y < 0 || y > n || z < 0 || z > n {
+				return 0
+			}
+			return values[x][y][z]
+		})
+		if len(m.Triangle) == 0 {
+			continue
+		}
+		checkSolid(t, m)
+		if t.Failed() {
+			t.Fatalf("trial #%d", trial)
+		}
+	}
+}
+
+func TestMarchingCubes33Sphere(t *testing.T) {
+	size := Vector{2, 3, 4}
+	m := MarchingCubes33Mesh(sphereField, 24, 0, size)
+	checkSolid(t, m)
+	want := signedVolume(MarchingCubesMesh(sphereField, 24, 0, size))
+	if got := signedVolume(m); math.Abs(got-want) > 1e-3*want {
+		t.Errorf("signed volume: want %f (as in MarchingCubesMesh), got %f", want, got)
+	}
+	soup := MarchingCubes33(sphereField, 24, 0, size)
+	if len(soup) != len(m.Triangle) {
+		t.Errorf("MarchingCubes33 returned %d triangles, want %d", len(soup), len(m.Triangle))
+	}
+}
+
+// components returns the number of connected components of the mesh.
+func components(m *Mesh) int {
+	parent := make([]int, len(m.Vertex))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for _, tr := range m.Triangle {
+		parent[find(tr[0])] = find(tr[1])
+		parent[find(tr[1])] = find(tr[2])
+	}
+	var cnt int
+	for i := range parent {
+		if find(i) == i {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func TestMarchingCubes33Tunnel(t *testing.T) {
+	// The corners 0 and 6 are solid, and the rest are empty (the case 4 of MC33).
+	// The trilinear interpolant connects the solid corners through the cube,
+	// if the values at the solid corners are large enough.
+	for _, tt := range []struct {
+		solid, empty float64
+		want         int
+	}{
+		{solid: 1, empty: -0.1, want: 1},
+		{solid: 0.1, empty: -1, want: 2},
+	} {
+		m := march33([3]int{3, 3, 3}, func(x, y, z int) float64 {
+			if x == 1 && y == 1 && z == 1 || x == 2 && y == 2 && z == 2 {
+				return tt.solid
+			}
+			if x < 1 || x > 2 || y < 1 || y > 2 || z < 1 || z > 2 {
+				return -1
+			}
+			return tt.empty
+		})
+		checkSolid(t, m)
+		if got := components(m); got != tt.want {
+			t.Errorf("solid: %f, empty: %f: want %d components, got %d", tt.solid, tt.empty, tt.want, got)
+		}
+	}
+}
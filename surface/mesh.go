@@ -0,0 +1,74 @@
+package surface
+
+import (
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+)
+
+// Mesh is an indexed triangle mesh. Unlike a triangle soup,
+// adjacent triangles share vertices, so the connectivity is preserved.
+type Mesh struct {
+	Vertex []Vector
+	// Normal contains per-vertex normals, one for each vertex.
+	Normal []Vector
+	// Triangle contains the indices of the triangle vertices.
+	Triangle [][3]int
+}
+
+// STL converts the mesh to a triangle soup.
+// The facet normals are computed from the vertex order.
+func (m *Mesh) STL() []stl.Triangle {
+	t := make([]stl.Triangle, len(m.Triangle))
+	for i, tr := range m.Triangle {
+		t[i] = facet(m.Vertex[tr[0]], m.Vertex[tr[1]], m.Vertex[tr[2]])
+	}
+	return t
+}
+
+// Flip reverses the orientation of the mesh: the order of the triangle vertices
+// and the direction of the normals. It's useful for the fields, which are
+// positive outside of the solid, like signed distance fields.
+func (m *Mesh) Flip() {
+	for i := range m.Triangle {
+		m.Triangle[i][1], m.Triangle[i][2] = m.Triangle[i][2], m.Triangle[i][1]
+	}
+	for i, nv := range m.Normal {
+		m.Normal[i] = Vector{-nv.X, -nv.Y, -nv.Z}
+	}
+}
+
+// facet returns the triangle abc with the normal, which follows the right-hand rule,
+// i.e. it looks outwards if the triangle is counterclockwise when viewed from the outside.
+func facet(a, b, c Vector) stl.Triangle {
+	nv := normalizeVector(CrossProduct(SubVector(b, a), SubVector(c, a)))
+	return stl.Triangle{
+		N: stl.Point{nv.X, nv.Y, nv.Z},
+		V: [3]stl.Point{{a.X, a.Y, a.Z}, {b.X, b.Y, b.Z}, {c.X, c.Y, c.Z}},
+	}
+}
+
+// a2iEdgeBase lists the offset of the lower endpoint of each of the 12 edges of the cube.
+// Together with a2iEdgeAxis, it identifies the edge in the grid, which allows
+// to share the edge vertices between adjacent cubes.
+var a2iEdgeBase = [12][3]int{
+	{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {0, 0, 0},
+	{0, 0, 1}, {1, 0, 1}, {0, 1, 1}, {0, 0, 1},
+	{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0},
+}
+
+// aiEdgeAxis lists the axis parallel to each of the 12 edges of the cube.
+var aiEdgeAxis = [12]int{
+	0, 1, 0, 1,
+	0, 1, 0, 1,
+	2, 2, 2, 2,
+}
+
+// MarchingCubesMesh is like MarchingCubes, but it returns an indexed mesh.
+// The vertices on the edges of the grid are shared between all cubes adjacent
+// to the edge, so the mesh is watertight if the surface does not touch the boundary of the grid.
+// The vertex normals are the gradients of the grid samples interpolated along the edges,
+// so the field is sampled only at the grid nodes.
+func MarchingCubesMesh(field g3.ScalarField, n int, threshold float64, size Vector) *Mesh {
+	cubes, p0, step := unitGrid(n)
+	return marchMesh(newMarcher(field, cubes, p0, step, threshold, size), 1)
+}
@@ -0,0 +1,125 @@
+package surface
+
+import (
+	"math"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+)
+
+func sphereField(p g3.Point) float64 {
+	dx, dy, dz := p[0]-0.5, p[1]-0.45, p[2]-0.52
+	return 1 - math.Sqrt(dx*dx+dy*dy+dz*dz)/0.3
+}
+
+// checkClosed verifies that every edge of the mesh is shared by exactly two triangles
+// which traverse it in opposite directions.
+func checkClosed(t *testing.T, m *Mesh) {
+	if len(m.Triangle) == 0 {
+		t.Fatalf("the mesh is empty")
+	}
+	directed := make(map[[2]int]int)
+	for _, tr := range m.Triangle {
+		for i := 0; i < 3; i++ {
+			a, b := tr[i], tr[(i+1)%3]
+			if a == b {
+				continue
+			}
+			directed[[2]int{a, b}]++
+		}
+	}
+	for e, cnt := range directed {
+		if cnt != 1 {
+			t.Fatalf("edge %v is used %d times in the same direction", e, cnt)
+		}
+		if directed[[2]int{e[1], e[0]}] != 1 {
+			t.Fatalf("edge %v has no matching opposite edge", e)
+		}
+	}
+}
+
+func pointsClose(a, b stl.Point) bool {
+	for i := range a {
+		if math.Abs(float64(a[i])-float64(b[i])) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMarchingCubesMesh(t *testing.T) {
+	size := Vector{2, 3, 4}
+	soup := MarchingCubes(sphereField, 24, 0, size)
+	m := MarchingCubesMesh(sphereField, 24, 0, size)
+	if len(m.Triangle) != len(soup) {
+		t.Fatalf("want %d triangles, got %d", len(soup), len(m.Triangle))
+	}
+	if len(m.Vertex) >= len(soup) {
+		t.Errorf("vertices are not shared: %d vertices for %d triangles", len(m.Vertex), len(m.Triangle))
+	}
+	for i, tr := range m.STL() {
+		for j := 0; j < 3; j++ {
+			if !pointsClose(tr.V[j], soup[i].V[j]) {
+				t.Fatalf("triangle #%d, vertex #%d: want %v, got %v", i, j, soup[i].V[j], tr.V[j])
+			}
+		}
+	}
+	checkClosed(t, m)
+}
+
+// indexSoup merges the vertices of the triangle soup which coincide up to rounding errors.
+func indexSoup(t []stl.Triangle) *Mesh {
+	m := new(Mesh)
+	index := make(map[[3]int64]int)
+	for _, tr := range t {
+		var cur [3]int
+		for j, p := range tr.V {
+			var key [3]int64
+			for k := range key {
+				key[k] = int64(math.Floor(float64(p[k])*1e7 + 0.5))
+			}
+			ind, ok := index[key]
+			if !ok {
+				ind = len(m.Vertex)
+				index[key] = ind
+				m.Vertex = append(m.Vertex, Vector{float64(p[0]), float64(p[1]), float64(p[2])})
+			}
+			cur[j] = ind
+		}
+		m.Triangle = append(m.Triangle, cur)
+	}
+	return m
+}
+
+// signedVolume returns the volume enclosed by the mesh. It's positive
+// if the triangles are oriented counterclockwise when viewed from the outside.
+func signedVolume(m *Mesh) (res float64) {
+	for _, tr := range m.Triangle {
+		a, b, c := m.Vertex[tr[0]], m.Vertex[tr[1]], m.Vertex[tr[2]]
+		cr := CrossProduct(b, c)
+		res += a.X*cr.X + a.Y*cr.Y + a.Z*cr.Z
+	}
+	return res / 6
+}
+
+func TestMarchingTetrahedra(t *testing.T) {
+	size := Vector{1, 1, 1}
+	// The adjacent cubes must sample their shared corners at exactly the same points.
+	points := make(map[g3.Point]bool)
+	field := func(p g3.Point) float64 {
+		points[p] = true
+		return sphereField(p)
+	}
+	m := indexSoup(MarchingTetrahedra(field, 20, 0, size))
+	if want := 21 * 21 * 21; len(points) != want {
+		t.Errorf("the field is sampled at %d points, want %d", len(points), want)
+	}
+	checkClosed(t, m)
+
+	want := signedVolume(indexSoup(MarchingCubes(sphereField, 20, 0, size)))
+	got := signedVolume(m)
+	if math.Abs(got-want) > 0.02*math.Abs(want) {
+		t.Errorf("signed volume: want %f (as in MarchingCubes), got %f", want, got)
+	}
+}
@@ -0,0 +1,140 @@
+package surface
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+)
+
+// MarchingCubesParallel is like MarchingCubes, but it splits the grid into slabs
+// along X and processes them with the given number of goroutines.
+// If workers <= 0, runtime.NumCPU() is used. The field must be safe for concurrent use.
+// The result is exactly the same as the one of MarchingCubes.
+func MarchingCubesParallel(field g3.ScalarField, n int, threshold float64, size Vector, workers int) []stl.Triangle {
+	cubes, p0, step := unitGrid(n)
+	return marchSoup(newMarcher(field, cubes, p0, step, threshold, size), workers)
+}
+
+// MarchingCubesMeshParallel is like MarchingCubesMesh, but it processes
+// the slabs with the given number of goroutines. See MarchingCubesParallel.
+// The meshes built for the slabs are stitched in order, so the result
+// is exactly the same as the one of MarchingCubesMesh.
+func MarchingCubesMeshParallel(field g3.ScalarField, n int, threshold float64, size Vector, workers int) *Mesh {
+	cubes, p0, step := unitGrid(n)
+	return marchMesh(newMarcher(field, cubes, p0, step, threshold, size), workers)
+}
+
+// marchSoup runs the marcher with the given number of goroutines and returns a triangle soup.
+func marchSoup(m *marcher, workers int) []stl.Triangle {
+	if workers == 1 {
+		e := new(soupEmitter)
+		m.run(0, m.n[0], e)
+		return e.t
+	}
+	ranges := splitSlabs(m.n[0], workers)
+	parts := make([]*soupEmitter, len(ranges))
+	runParallel(ranges, func(i int, x0, x1 int) {
+		parts[i] = new(soupEmitter)
+		m.clone().run(x0, x1, parts[i])
+	})
+
+	var total int
+	for _, e := range parts {
+		total += len(e.t)
+	}
+	t := make([]stl.Triangle, 0, total)
+	for _, e := range parts {
+		t = append(t, e.t...)
+	}
+	return t
+}
+
+// marchMesh runs the marcher with the given number of goroutines and returns an indexed mesh.
+func marchMesh(m *marcher, workers int) *Mesh {
+	if workers == 1 {
+		e := newMeshEmitter(m.n)
+		m.run(0, m.n[0], e)
+		return e.m
+	}
+	ranges := splitSlabs(m.n[0], workers)
+	parts := make([]*meshEmitter, len(ranges))
+	runParallel(ranges, func(i int, x0, x1 int) {
+		parts[i] = newMeshEmitter(m.n)
+		parts[i].keepFirst = i > 0
+		m.clone().run(x0, x1, parts[i])
+	})
+	return stitchMeshes(parts)
+}
+
+// splitSlabs splits [0, n) into at most workers ranges of about the same size.
+func splitSlabs(n, workers int) [][2]int {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	ranges := make([][2]int, workers)
+	for i := range ranges {
+		ranges[i] = [2]int{n * i / workers, n * (i + 1) / workers}
+	}
+	return ranges
+}
+
+func runParallel(ranges [][2]int, f func(i, x0, x1 int)) {
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i, x0, x1 int) {
+			defer wg.Done()
+			f(i, x0, x1)
+		}(i, r[0], r[1])
+	}
+	wg.Wait()
+}
+
+// stitchMeshes joins the meshes built for the consecutive slabs.
+// The vertices on the first plane of a slab were also created by the previous slab.
+// Like the serial version, the copies from the previous slab are kept.
+func stitchMeshes(parts []*meshEmitter) *Mesh {
+	res := new(Mesh)
+	// last maps the edges on the last plane of the previous slab to the vertices of res.
+	var last []int
+	for i, e := range parts {
+		local := e.m
+		remap := make([]int, len(local.Vertex))
+		resetEdges(remap)
+		if i > 0 && e.first != nil {
+			for j, v := range e.first {
+				if v >= 0 {
+					remap[v] = last[j]
+				}
+			}
+		}
+		for v := range local.Vertex {
+			if remap[v] >= 0 {
+				continue
+			}
+			remap[v] = len(res.Vertex)
+			res.Vertex = append(res.Vertex, local.Vertex[v])
+			res.Normal = append(res.Normal, local.Normal[v])
+		}
+		for _, tr := range local.Triangle {
+			res.Triangle = append(res.Triangle, [3]int{remap[tr[0]], remap[tr[1]], remap[tr[2]]})
+		}
+
+		last = make([]int, len(e.yzEdges[0]))
+		for j, v := range e.yzEdges[0] {
+			last[j] = -1
+			if v >= 0 {
+				last[j] = remap[v]
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,21 @@
+package surface
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarchingCubesParallel(t *testing.T) {
+	const n = 23
+	size := Vector{2, 3, 4}
+	soup := MarchingCubes(sphereField, n, 0, size)
+	m := MarchingCubesMesh(sphereField, n, 0, size)
+	for _, workers := range []int{0, 1, 2, 3, 7, n + 5} {
+		if got := MarchingCubesParallel(sphereField, n, 0, size, workers); !reflect.DeepEqual(got, soup) {
+			t.Errorf("MarchingCubesParallel(workers=%d) differs from MarchingCubes", workers)
+		}
+		if got := MarchingCubesMeshParallel(sphereField, n, 0, size, workers); !reflect.DeepEqual(got, m) {
+			t.Errorf("MarchingCubesMeshParallel(workers=%d) differs from MarchingCubesMesh", workers)
+		}
+	}
+}
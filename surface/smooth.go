@@ -0,0 +1,183 @@
+package surface
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/krasin/g3"
+)
+
+// SmoothOptions control the mesh smoothing.
+type SmoothOptions struct {
+	// Iterations is the number of smoothing steps. A Taubin step is a pair of passes.
+	Iterations int
+	// Lambda and Mu are the scale factors of the Taubin passes: the shrinking one (0 < Lambda < 1)
+	// and the inflating one (Mu < -Lambda). Only Lambda is used by the Laplacian smoothing.
+	Lambda, Mu float64
+	// Pinned marks the vertices, which must not move. It's either nil or has one entry per vertex,
+	// otherwise Taubin and Laplacian panic before moving anything.
+	Pinned []bool
+	// MaxDisplacement is the largest distance, by which a vertex may move from its original position.
+	// Zero means no limit.
+	MaxDisplacement float64
+	// FeatureAngle is the dihedral angle in radians, above which an edge is a feature (a crease).
+	// The vertices on a feature only move along it, and the corners, where more than two
+	// features meet, don't move at all. Zero means no features.
+	FeatureAngle float64
+}
+
+// DefaultSmoothOptions returns the options, which remove the staircasing of a surface
+// extracted from a voxel volume over the grid g with the given number of voxels along each axis.
+// The surface never moves by more than half a voxel. The staircase of the voxels looks like
+// a row of creases, so there are no features.
+func DefaultSmoothOptions(g g3.Grid, voxels int) SmoothOptions {
+	return SmoothOptions{
+		Iterations:      30,
+		Lambda:          0.5,
+		Mu:              -0.53,
+		MaxDisplacement: g.Side() / float64(voxels) / 2,
+	}
+}
+
+// Taubin smooths the mesh with the λ|μ algorithm, see G. Taubin, "A Signal Processing Approach
+// to Fair Surface Design". Every step moves each vertex towards the average of its neighbours
+// by Lambda, and then away from it by Mu, so the mesh doesn't shrink like with the plain
+// Laplacian smoothing. The vertex normals are recomputed.
+func (m *Mesh) Taubin(opt SmoothOptions) {
+	s := newSmoother(m, opt)
+	for i := 0; i < opt.Iterations; i++ {
+		s.pass(opt.Lambda)
+		s.pass(opt.Mu)
+	}
+	m.computeNormals()
+}
+
+// Laplacian smooths the mesh by moving each vertex towards the average of its neighbours
+// by Lambda on every step. It shrinks the mesh, so opt.MaxDisplacement and opt.FeatureAngle
+// are the way to keep it close to the original. The vertex normals are recomputed.
+func (m *Mesh) Laplacian(opt SmoothOptions) {
+	s := newSmoother(m, opt)
+	for i := 0; i < opt.Iterations; i++ {
+		s.pass(opt.Lambda)
+	}
+	m.computeNormals()
+}
+
+type smoother struct {
+	m    *Mesh
+	opt  SmoothOptions
+	orig []Vector
+	// nb contains the neighbours, which are averaged for each vertex.
+	// A vertex without neighbours doesn't move.
+	nb  [][]int
+	buf []Vector
+}
+
+func newSmoother(m *Mesh, opt SmoothOptions) *smoother {
+	if opt.Pinned != nil && len(opt.Pinned) != len(m.Vertex) {
+		panic(fmt.Sprintf("surface: SmoothOptions.Pinned has %d entries for %d vertices", len(opt.Pinned), len(m.Vertex)))
+	}
+	s := &smoother{
+		m:    m,
+		opt:  opt,
+		orig: append([]Vector(nil), m.Vertex...),
+		nb:   make([][]int, len(m.Vertex)),
+		buf:  make([]Vector, len(m.Vertex)),
+	}
+
+	// Find the triangles around each edge to detect the boundary and the features.
+	// The edges are listed in the order of the triangles, so the neighbours are summed
+	// in the same order on every run.
+	edgeTris := make(map[[2]int][]int)
+	var edges [][2]int
+	for i, tr := range m.Triangle {
+		for j := range tr {
+			a, b := tr[j], tr[(j+1)%3]
+			if a > b {
+				a, b = b, a
+			}
+			e := [2]int{a, b}
+			if edgeTris[e] == nil {
+				edges = append(edges, e)
+			}
+			edgeTris[e] = append(edgeTris[e], i)
+		}
+	}
+	cosFeature := math.Cos(opt.FeatureAngle)
+	all := make([][]int, len(m.Vertex))
+	feature := make([][]int, len(m.Vertex))
+	for _, e := range edges {
+		tris := edgeTris[e]
+		all[e[0]] = append(all[e[0]], e[1])
+		all[e[1]] = append(all[e[1]], e[0])
+		// The boundary edges are features, so the boundary only slides along itself.
+		isFeature := len(tris) != 2
+		if !isFeature && opt.FeatureAngle > 0 {
+			// The degenerate triangles have zero normals and don't make features.
+			n0, n1 := m.triangleNormal(tris[0]), m.triangleNormal(tris[1])
+			isFeature = DotProduct(n0, n0) > 0 && DotProduct(n1, n1) > 0 && DotProduct(n0, n1) < cosFeature
+		}
+		if isFeature {
+			feature[e[0]] = append(feature[e[0]], e[1])
+			feature[e[1]] = append(feature[e[1]], e[0])
+		}
+	}
+	for v := range s.nb {
+		switch {
+		case opt.Pinned != nil && opt.Pinned[v]:
+		case len(feature[v]) == 0:
+			s.nb[v] = all[v]
+		case len(feature[v]) == 2:
+			s.nb[v] = feature[v]
+		}
+	}
+	return s
+}
+
+func (m *Mesh) triangleNormal(i int) Vector {
+	tr := m.Triangle[i]
+	a, b, c := m.Vertex[tr[0]], m.Vertex[tr[1]], m.Vertex[tr[2]]
+	return normalizeVector(CrossProduct(SubVector(b, a), SubVector(c, a)))
+}
+
+// pass moves every vertex by k times the vector to the average of its neighbours.
+func (s *smoother) pass(k float64) {
+	v := s.m.Vertex
+	for i, nb := range s.nb {
+		s.buf[i] = v[i]
+		if len(nb) == 0 {
+			continue
+		}
+		var avg Vector
+		for _, j := range nb {
+			avg = AddVector(avg, v[j])
+		}
+		avg = ScaleVector(avg, 1/float64(len(nb)))
+		p := AddVector(v[i], ScaleVector(SubVector(avg, v[i]), k))
+		if max := s.opt.MaxDisplacement; max > 0 {
+			d := SubVector(p, s.orig[i])
+			if l := math.Sqrt(DotProduct(d, d)); l > max {
+				p = AddVector(s.orig[i], ScaleVector(d, max/l))
+			}
+		}
+		s.buf[i] = p
+	}
+	copy(v, s.buf)
+}
+
+// computeNormals sets the vertex normals to the area weighted averages of the triangle normals.
+func (m *Mesh) computeNormals() {
+	m.Normal = make([]Vector, len(m.Vertex))
+	for _, tr := range m.Triangle {
+		a, b, c := m.Vertex[tr[0]], m.Vertex[tr[1]], m.Vertex[tr[2]]
+		n := CrossProduct(SubVector(b, a), SubVector(c, a))
+		for _, v := range tr {
+			m.Normal[v] = AddVector(m.Normal[v], n)
+		}
+	}
+	for i, n := range m.Normal {
+		if DotProduct(n, n) > 0 {
+			m.Normal[i] = normalizeVector(n)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package surface
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/krasin/g3"
+)
+
+// voxelSphereField is 1 inside of the sphere and 0 outside, so its surface has steps.
+func voxelSphereField(p g3.Point) float64 {
+	if sphereField(p) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// radiusDeviation returns the RMS deviation of the distances from the vertices to the center of sphereField.
+func radiusDeviation(m *Mesh) float64 {
+	var sum, sum2 float64
+	for _, v := range m.Vertex {
+		r := math.Sqrt((v.X-0.5)*(v.X-0.5) + (v.Y-0.45)*(v.Y-0.45) + (v.Z-0.52)*(v.Z-0.52))
+		sum += r
+		sum2 += r * r
+	}
+	n := float64(len(m.Vertex))
+	return math.Sqrt(sum2/n - sum*sum/n/n)
+}
+
+func TestTaubin(t *testing.T) {
+	const n = 32
+	m := MarchingCubesMesh(voxelSphereField, n, 0.5, Vector{1, 1, 1})
+	orig := append([]Vector(nil), m.Vertex...)
+	before, volBefore := radiusDeviation(m), signedVolume(m)
+
+	opt := DefaultSmoothOptions(g3.Grid{N: n, H: 1. / n}, n)
+	opt.Pinned = make([]bool, len(m.Vertex))
+	opt.Pinned[0] = true
+	m.Taubin(opt)
+
+	checkClosed(t, m)
+	if after := radiusDeviation(m); after > before/2 {
+		t.Errorf("the staircase is not smoothed: the deviation of the radius is %f before and %f after", before, after)
+	}
+	if vol := signedVolume(m); math.Abs(vol-volBefore) > 0.02*volBefore {
+		t.Errorf("the volume: want %f, got %f", volBefore, vol)
+	}
+	if m.Vertex[0] != orig[0] {
+		t.Errorf("the pinned vertex is moved from %v to %v", orig[0], m.Vertex[0])
+	}
+	for i, v := range m.Vertex {
+		if d := distance(v, orig[i]); d > 0.5/n+1e-12 {
+			t.Fatalf("vertex %d is moved by %f, more than half a voxel", i, d)
+		}
+	}
+	if len(m.Normal) != len(m.Vertex) {
+		t.Errorf("want %d normals, got %d", len(m.Vertex), len(m.Normal))
+	}
+}
+
+func TestTaubinShortPinned(t *testing.T) {
+	m := MarchingCubesMesh(voxelSphereField, 16, 0.5, Vector{1, 1, 1})
+	orig := append([]Vector(nil), m.Vertex...)
+	opt := DefaultSmoothOptions(g3.Grid{N: 16, H: 1. / 16}, 16)
+	opt.Pinned = make([]bool, len(m.Vertex)-1)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Taubin with a short Pinned must panic")
+		}
+		if !reflect.DeepEqual(m.Vertex, orig) {
+			t.Errorf("Taubin moved the vertices before panicking")
+		}
+	}()
+	m.Taubin(opt)
+}
+
+func TestLaplacianFeatures(t *testing.T) {
+	const n = 40
+	opt := SmoothOptions{Iterations: 20, Lambda: 0.5}
+	plain := MarchingCubesMesh(boxField, n, 0, Vector{1, 1, 1})
+	vol := signedVolume(plain)
+	plain.Laplacian(opt)
+
+	opt.FeatureAngle = math.Pi / 6
+	m := MarchingCubesMesh(boxField, n, 0, Vector{1, 1, 1})
+	m.Laplacian(opt)
+	checkClosed(t, m)
+
+	lostPlain, lost := vol-signedVolume(plain), vol-signedVolume(m)
+	if lost < 0 || lost > lostPlain/4 {
+		t.Errorf("the volume lost with the features: %f, without them: %f", lost, lostPlain)
+	}
+	// The faces stay flat.
+	for _, v := range m.Vertex {
+		if v.X < 0.2-1e-9 || v.X > 0.7+1e-9 || v.Y < 0.25-1e-9 || v.Y > 0.65+1e-9 || v.Z < 0.3-1e-9 || v.Z > 0.8+1e-9 {
+			t.Fatalf("vertex %v is out of the box", v)
+		}
+	}
+}
+
+func TestTaubinDeterministic(t *testing.T) {
+	var want []Vector
+	for i := 0; i < 5; i++ {
+		m := MarchingCubesMesh(voxelSphereField, 16, 0.5, Vector{1, 1, 1})
+		opt := DefaultSmoothOptions(g3.Grid{N: 16, H: 1. / 16}, 16)
+		opt.FeatureAngle = 0.5
+		m.Taubin(opt)
+		if i == 0 {
+			want = m.Vertex
+		} else if !reflect.DeepEqual(m.Vertex, want) {
+			t.Fatalf("run %d: the vertices differ from the first run", i+1)
+		}
+	}
+}
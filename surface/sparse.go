@@ -0,0 +1,87 @@
+package surface
+
+import (
+	"github.com/krasin/g3"
+	"github.com/krasin/stl"
+	"github.com/krasin/voxel/volume"
+)
+
+// MarchingCubesVolume extracts the surface of the filled voxels.
+// The voxel (x, y, z) is placed at (x, y, z) / n of the unit cube, which is then scaled by size.
+//
+// If vol is a *volume.SparseVolume, only the leaf cubes which may contain the surface are visited:
+// the non-uniform ones and the uniform ones adjacent to a cube with different content.
+// So, the extraction time is proportional to the surface area, not to the volume.
+func MarchingCubesVolume(vol volume.Space16, size Vector) []stl.Triangle {
+	e := new(soupEmitter)
+	sv, ok := vol.(*volume.SparseVolume)
+	if !ok {
+		n := vol.Size()
+		marchVolumeBox(vol, [3]int{-1, -1, -1}, [3]int{n[0] + 1, n[1] + 1, n[2] + 1}, size, e)
+		return e.t
+	}
+
+	for k, leaf := range sv.Cubes {
+		c := sv.K2cube(k)
+		if !sv.CubeInside(c) || leaf == nil && !needsVisit(sv, c) {
+			continue
+		}
+		var lo, cnt [3]int
+		for i := range c {
+			lo[i] = c[i] * volume.LeafSide
+			cnt[i] = volume.LeafSide
+			if c[i] == 0 {
+				// The cubes between the boundary of the space and the first voxel.
+				lo[i]--
+				cnt[i]++
+			}
+		}
+		marchVolumeBox(sv, lo, cnt, size, e)
+	}
+	return e.t
+}
+
+// needsVisit reports whether the cubes of the grid, which have the vertex 0
+// within the uniform leaf cube c, may be intersected by the surface.
+func needsVisit(vol *volume.SparseVolume, c [3]int) bool {
+	side := vol.CubeCount()
+	filled := vol.Colors[vol.Cube2k(c)] != 0
+	for i := range c {
+		if c[i] == 0 && filled {
+			// The boundary of the space is adjacent to the filled cube.
+			return true
+		}
+	}
+	for d := 1; d < 8; d++ {
+		c2 := c
+		c2[0] += d & 1
+		c2[1] += (d >> 1) & 1
+		c2[2] += (d >> 2) & 1
+		if c2[0] >= side[0] || c2[1] >= side[1] || c2[2] >= side[2] {
+			// Out of the space, which is empty.
+			if filled {
+				return true
+			}
+			continue
+		}
+		k2 := vol.Cube2k(c2)
+		if vol.Cubes[k2] != nil || (vol.Colors[k2] != 0) != filled {
+			return true
+		}
+	}
+	return false
+}
+
+// marchVolumeBox runs Marching Cubes over cnt grid cubes starting from the voxel lo.
+func marchVolumeBox(vol volume.Space16, lo, cnt [3]int, size Vector, e emitter) {
+	step := 1.0 / float64(vol.N())
+	p0 := [3]float64{float64(lo[0]) * step, float64(lo[1]) * step, float64(lo[2]) * step}
+	m := newMarcher(nil, cnt, p0, [3]float64{step, step, step}, 0.5, size)
+	m.node = func(x, y, z int) float64 {
+		if vol.Get(g3.Node{lo[0] + x, lo[1] + y, lo[2] + z}) {
+			return 1
+		}
+		return 0
+	}
+	m.run(0, cnt[0], e)
+}
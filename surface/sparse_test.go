@@ -0,0 +1,46 @@
+package surface
+
+import (
+	"math"
+	"testing"
+
+	"github.com/krasin/g3"
+	"github.com/krasin/voxel/volume"
+)
+
+// hiddenVolume hides the type of the volume, so the generic code path is used.
+type hiddenVolume struct {
+	volume.Space16
+}
+
+func TestMarchingCubesVolume(t *testing.T) {
+	// The box cuts the uniform cubes below.
+	for _, n := range []g3.Node{{128, 128, 128}, {128, 100, 112}} {
+		vol := volume.NewSparseVolumeSize(n)
+		// A ball crossing the borders of the leaf cubes.
+		for x := 0; x < 70; x++ {
+			for y := 0; y < 70; y++ {
+				for z := 0; z < 70; z++ {
+					dx, dy, dz := float64(x)-40, float64(y)-33, float64(z)-30
+					if dx*dx+dy*dy+dz*dz < 25*25 {
+						vol.Set16(g3.Node{x, y, z}, 1)
+					}
+				}
+			}
+		}
+		// A uniform leaf cube touching the boundary of the space and a uniform neighbour of it.
+		vol.Colors[vol.Cube2k(g3.Node{3, 0, 3})] = 2
+		vol.Colors[vol.Cube2k(g3.Node{3, 1, 3})] = 3
+
+		size := Vector{1, 1, 1}
+		got := indexSoup(MarchingCubesVolume(vol, size))
+		want := indexSoup(MarchingCubesVolume(hiddenVolume{vol}, size))
+		checkClosed(t, got)
+		if len(got.Triangle) != len(want.Triangle) {
+			t.Errorf("size %v: want %d triangles, got %d", n, len(want.Triangle), len(got.Triangle))
+		}
+		if v1, v2 := signedVolume(got), signedVolume(want); math.Abs(v1-v2) > 1e-9 {
+			t.Errorf("size %v: signed volume: want %f, got %f", n, v2, v1)
+		}
+	}
+}
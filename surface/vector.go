@@ -0,0 +1,30 @@
+package surface
+
+// AddVector returns a + b.
+func AddVector(a, b Vector) Vector {
+	return Vector{a.X + b.X, a.Y + b.Y, a.Z + b.Z}
+}
+
+// SubVector returns a - b.
+func SubVector(a, b Vector) Vector {
+	return Vector{a.X - b.X, a.Y - b.Y, a.Z - b.Z}
+}
+
+// ScaleVector returns a multiplied by k.
+func ScaleVector(a Vector, k float64) Vector {
+	return Vector{a.X * k, a.Y * k, a.Z * k}
+}
+
+// DotProduct returns the dot product of a and b.
+func DotProduct(a, b Vector) float64 {
+	return a.X*b.X + a.Y*b.Y + a.Z*b.Z
+}
+
+// CrossProduct returns the cross product of a and b.
+func CrossProduct(a, b Vector) Vector {
+	return Vector{
+		a.Y*b.Z - a.Z*b.Y,
+		a.Z*b.X - a.X*b.Z,
+		a.X*b.Y - a.Y*b.X,
+	}
+}
@@ -0,0 +1,239 @@
+package volume
+
+import (
+	"math/bits"
+
+	"github.com/krasin/g3"
+)
+
+// cubeWords is the number of 64-bit words in a leaf cube of BitVolume.
+const cubeWords = 1 << (3*lh - 6)
+
+// BitVolume is a box of voxels, which only knows if the voxel is filled or not.
+// It has the same layout as SparseVolume, but every voxel takes one bit,
+// so the volume takes 16 times less memory.
+// The bit h of the leaf cube is the voxel h2point(h), so the rows along z are
+// the halves of the words: the row (x, y) is the half y%2 of the word x*LeafSide/2 + y/2.
+type BitVolume struct {
+	layout
+	// Cubes contains the bits of the leaf cubes. It's nil for the uniform cubes.
+	Cubes [][]uint64
+	// Filled tells if the uniform cube is filled.
+	Filled []bool
+}
+
+// NewBitVolume creates an empty voxel cube with side n.
+func NewBitVolume(n int) *BitVolume {
+	return NewBitVolumeSize(g3.Node{n, n, n})
+}
+
+// NewBitVolumeSize creates an empty box of voxels with the given number of voxels along each axis.
+func NewBitVolumeSize(size g3.Node) *BitVolume {
+	l := newLayout(size)
+	return &BitVolume{
+		layout: l,
+		Cubes:  make([][]uint64, l.count),
+		Filled: make([]bool, l.count),
+	}
+}
+
+// NewBitVolumeFrom returns the filled voxels of vol. The uniform cubes of SparseVolume
+// are converted without touching the voxels.
+func NewBitVolumeFrom(vol Space) *BitVolume {
+	size := vol.Size()
+	res := NewBitVolumeSize(size)
+	if sv, ok := vol.(*SparseVolume); ok {
+		for k, cube := range sv.Cubes {
+			if cube == nil {
+				res.Filled[k] = sv.Colors[k] != 0
+				continue
+			}
+			words := make([]uint64, cubeWords)
+			for h, v := range cube {
+				if v != 0 {
+					words[h>>6] |= 1 << uint(h&63)
+				}
+			}
+			res.Cubes[k] = words
+		}
+		res.Compact()
+		return res
+	}
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				if node := (g3.Node{x, y, z}); vol.Get(node) {
+					res.Set(node, true)
+				}
+			}
+		}
+	}
+	res.Compact()
+	return res
+}
+
+// SparseVolume returns the volume, where the filled voxels have the given color.
+func (v *BitVolume) SparseVolume(color uint16) *SparseVolume {
+	res := NewSparseVolumeSize(v.size)
+	for k, words := range v.Cubes {
+		if words == nil {
+			if v.Filled[k] {
+				res.Colors[k] = color
+			}
+			continue
+		}
+		cube := make([]uint16, 1<<(3*lh))
+		for h := range cube {
+			if words[h>>6]&(1<<uint(h&63)) != 0 {
+				cube[h] = color
+			}
+		}
+		res.Cubes[k] = cube
+	}
+	return res
+}
+
+// Get returns true, if the voxel is filled. The voxels outside of the volume are empty.
+func (v *BitVolume) Get(node g3.Node) bool {
+	if !v.inside(node) {
+		return false
+	}
+	k := v.point2k(node)
+	if v.Cubes[k] == nil {
+		return v.Filled[k]
+	}
+	h := point2h(node)
+	return v.Cubes[k][h>>6]&(1<<uint(h&63)) != 0
+}
+
+// Set fills or clears the voxel.
+func (v *BitVolume) Set(node g3.Node, filled bool) {
+	if !v.inside(node) {
+		return
+	}
+	k := v.point2k(node)
+	if v.Cubes[k] == nil {
+		if v.Filled[k] == filled {
+			return
+		}
+		v.Cubes[k] = make([]uint64, cubeWords)
+		if v.Filled[k] {
+			for i := range v.Cubes[k] {
+				v.Cubes[k][i] = ^uint64(0)
+			}
+		}
+		v.Filled[k] = false
+	}
+	h := point2h(node)
+	if filled {
+		v.Cubes[k][h>>6] |= 1 << uint(h&63)
+	} else {
+		v.Cubes[k][h>>6] &^= 1 << uint(h&63)
+	}
+}
+
+// Volume returns the number of the filled voxels.
+func (v *BitVolume) Volume() (res int64) {
+	for k, words := range v.Cubes {
+		e := v.extent(k)
+		if words == nil {
+			if v.Filled[k] {
+				res += int64(e[0] * e[1] * e[2])
+			}
+			continue
+		}
+		if e == fullExtent {
+			for _, w := range words {
+				res += int64(bits.OnesCount64(w))
+			}
+			continue
+		}
+		p := v.k2point(k)
+		for x := p[0]; x < p[0]+e[0]; x++ {
+			for y := p[1]; y < p[1]+e[1]; y++ {
+				res += int64(bits.OnesCount32(v.row(x, y, p[2])))
+			}
+		}
+	}
+	return
+}
+
+// Compact folds the uniform leaf cubes and returns the number of bytes freed.
+func (v *BitVolume) Compact() (freed int64) {
+	for k, words := range v.Cubes {
+		if words == nil {
+			continue
+		}
+		// Only the rows within the volume are compared.
+		p, e := v.k2point(k), v.extent(k)
+		mask := rowMask(e[2])
+		first := v.row(p[0], p[1], p[2])
+		uniform := first == 0 || first == mask
+		for x := p[0]; x < p[0]+e[0] && uniform; x++ {
+			for y := p[1]; y < p[1]+e[1] && uniform; y++ {
+				uniform = v.row(x, y, p[2]) == first
+			}
+		}
+		if uniform {
+			v.Filled[k] = first != 0
+			v.Cubes[k] = nil
+			freed += 8 * cubeWords
+		}
+	}
+	return
+}
+
+// row returns the row of voxels (x, y, z0..z0+LeafSide-1) as bits, where z0 is a multiple of LeafSide.
+// The voxels outside of the volume are empty.
+func (v *BitVolume) row(x, y, z0 int) uint32 {
+	if !v.inside(g3.Node{x, y, z0}) {
+		return 0
+	}
+	k := v.point2k(g3.Node{x, y, z0})
+	mask := rowMask(v.size[2] - z0)
+	words := v.Cubes[k]
+	if words == nil {
+		if v.Filled[k] {
+			return mask
+		}
+		return 0
+	}
+	xx, yy := x&masklh, y&masklh
+	return uint32(words[xx*LeafSide/2+yy/2]>>(32*uint(yy&1))) & mask
+}
+
+// rowMask returns the bits of the first l voxels of a row.
+func rowMask(l int) uint32 {
+	if l >= LeafSide {
+		return ^uint32(0)
+	}
+	return 1<<uint(l) - 1
+}
+
+// MapBoundary invokes f on every filled voxel, which has an empty voxel among its 6 neighbours.
+// The voxels are tested a row along z at a time: a voxel is inside, if the rows next to it
+// and its neighbours in the row, which are the row shifted by one bit, are filled.
+func (v *BitVolume) MapBoundary(f func(node g3.Node)) {
+	for k, words := range v.Cubes {
+		if words == nil && !v.Filled[k] {
+			continue
+		}
+		p, e := v.k2point(k), v.extent(k)
+		for x := p[0]; x < p[0]+e[0]; x++ {
+			for y := p[1]; y < p[1]+e[1]; y++ {
+				row := v.row(x, y, p[2])
+				if row == 0 {
+					continue
+				}
+				below := row<<1 | v.row(x, y, p[2]-LeafSide)>>(LeafSide-1)
+				above := row>>1 | v.row(x, y, p[2]+LeafSide)<<(LeafSide-1)
+				inner := below & above &
+					v.row(x-1, y, p[2]) & v.row(x+1, y, p[2]) &
+					v.row(x, y-1, p[2]) & v.row(x, y+1, p[2])
+				for b := row &^ inner; b != 0; b &= b - 1 {
+					f(g3.Node{x, y, p[2] + bits.TrailingZeros32(b)})
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package volume
+
+import (
+	"testing"
+
+	"github.com/krasin/g3"
+)
+
+func TestBitVolume(t *testing.T) {
+	vol := morphTestVolume(g3.Node{70, 40, 75})
+	bits := NewBitVolumeFrom(vol)
+	if got, want := bits.Volume(), vol.Volume(); got != want {
+		t.Errorf("Volume: want %d, got %d", want, got)
+	}
+	if k := bits.Cube2k(g3.Node{1, 0, 1}); bits.Cubes[k] != nil || !bits.Filled[k] {
+		t.Errorf("the uniform cube must stay uniform")
+	}
+	back := bits.SparseVolume(1)
+	size := vol.Size()
+	for x := -1; x <= size[0]; x++ {
+		for y := -1; y <= size[1]; y++ {
+			for z := -1; z <= size[2]; z++ {
+				node := g3.Node{x, y, z}
+				if want := vol.Get(node); bits.Get(node) != want || back.Get(node) != want {
+					t.Fatalf("%v: want %v, got %v in BitVolume and %v in SparseVolume", node, want, bits.Get(node), back.Get(node))
+				}
+			}
+		}
+	}
+
+	// Set expands the uniform cube, and Compact folds it back.
+	node := g3.Node{40, 10, 40}
+	bits.Set(node, false)
+	if bits.Get(node) || !bits.Get(g3.Node{41, 10, 40}) {
+		t.Errorf("Set: only %v must be cleared", node)
+	}
+	bits.Set(node, true)
+	if freed := bits.Compact(); freed != 8*cubeWords {
+		t.Errorf("Compact: want %d bytes freed, got %d", 8*cubeWords, freed)
+	}
+}
+
+func TestBitVolumeMapBoundary(t *testing.T) {
+	vol := NewBitVolumeFrom(morphTestVolume(g3.Node{70, 40, 75}))
+	size := vol.Size()
+	want := make(map[g3.Node]bool)
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				if node := (g3.Node{x, y, z}); IsBoundary(vol, node) {
+					want[node] = true
+				}
+			}
+		}
+	}
+	got := make(map[g3.Node]bool)
+	vol.MapBoundary(func(node g3.Node) {
+		if got[node] {
+			t.Errorf("MapBoundary: %v is reported twice", node)
+		}
+		got[node] = true
+	})
+	if len(got) != len(want) {
+		t.Errorf("MapBoundary: want %d voxels, got %d", len(want), len(got))
+	}
+	for node := range want {
+		if !got[node] {
+			t.Fatalf("MapBoundary: %v is missing", node)
+		}
+	}
+}
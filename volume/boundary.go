@@ -4,7 +4,10 @@ import "github.com/krasin/g3"
 
 type Space interface {
 	Get(node g3.Node) bool
+	// N returns the largest side of the space.
 	N() int
+	// Size returns the number of voxels along each axis.
+	Size() g3.Node
 }
 
 type Space16 interface {
@@ -14,6 +17,8 @@ type Space16 interface {
 	SetAllFilled(threshold, val uint16)
 	MapBoundary(f func(node g3.Node))
 	Volume() int64
+	// Compact releases the memory, which is not needed anymore, and returns the number of bytes freed.
+	Compact() int64
 }
 
 func Normal(vol Space, node g3.Node) g3.Vector {
@@ -24,7 +29,7 @@ func Normal(vol Space, node g3.Node) g3.Vector {
 		if !vol.Get(cur) {
 			continue
 		}
-		p.Sub(vec)
+		p = p.Sub(vec)
 	}
 	if p.IsZero() {
 		return g3.Vector{1, 0, 0}
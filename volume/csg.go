@@ -0,0 +1,150 @@
+package volume
+
+import (
+	"fmt"
+
+	"github.com/krasin/g3"
+)
+
+// Op combines the colors of the voxels of two volumes. Op(0, 0) must be 0.
+type Op func(a, b uint16) uint16
+
+// OpUnion fills the voxels filled in any of the volumes. The color of the first one wins.
+func OpUnion(a, b uint16) uint16 {
+	if a != 0 {
+		return a
+	}
+	return b
+}
+
+// OpIntersect fills the voxels filled in both volumes with the color of the first one.
+func OpIntersect(a, b uint16) uint16 {
+	if b == 0 {
+		return 0
+	}
+	return a
+}
+
+// OpSubtract keeps the voxels of the first volume, which are empty in the second one.
+func OpSubtract(a, b uint16) uint16 {
+	if b != 0 {
+		return 0
+	}
+	return a
+}
+
+// OpXor fills the voxels filled in exactly one of the volumes.
+func OpXor(a, b uint16) uint16 {
+	if a != 0 && b != 0 {
+		return 0
+	}
+	return a | b
+}
+
+// Union returns the union of the volumes. They must have the same size.
+func Union(a, b *SparseVolume) *SparseVolume {
+	checkSameSide(a, b)
+	return Combine(a, b, g3.Node{}, OpUnion)
+}
+
+// Intersect returns the intersection of the volumes. They must have the same size.
+func Intersect(a, b *SparseVolume) *SparseVolume {
+	checkSameSide(a, b)
+	return Combine(a, b, g3.Node{}, OpIntersect)
+}
+
+// Subtract returns a without b. The volumes must have the same size.
+func Subtract(a, b *SparseVolume) *SparseVolume {
+	checkSameSide(a, b)
+	return Combine(a, b, g3.Node{}, OpSubtract)
+}
+
+// Xor returns the voxels filled in exactly one of the volumes. They must have the same size.
+func Xor(a, b *SparseVolume) *SparseVolume {
+	checkSameSide(a, b)
+	return Combine(a, b, g3.Node{}, OpXor)
+}
+
+// Combine returns the volume of the same size as a, where the voxel x has the color op(a(x), b(x-offset)),
+// i.e. b is moved by offset. If b has the same size as a and the offset is zero, or the offset is
+// a multiple of LeafSide, the volumes are combined one leaf cube at a time,
+// so two uniform cubes are combined in O(1).
+func Combine(a, b *SparseVolume, offset g3.Node, op Op) *SparseVolume {
+	if !offset.IsZero() || b.size != a.size {
+		b = Place(b, offset, a.size)
+	}
+	res := NewSparseVolumeSize(a.size)
+	for k := range a.Cubes {
+		ca, cb := a.Cubes[k], b.Cubes[k]
+		if ca == nil && cb == nil {
+			res.Colors[k] = op(a.Colors[k], b.Colors[k])
+			continue
+		}
+		cube := make([]uint16, 1<<(3*lh))
+		uniform := true
+		for h := range cube {
+			va, vb := a.Colors[k], b.Colors[k]
+			if ca != nil {
+				va = ca[h]
+			}
+			if cb != nil {
+				vb = cb[h]
+			}
+			cube[h] = op(va, vb)
+			uniform = uniform && cube[h] == cube[0]
+		}
+		if uniform {
+			res.Colors[k] = cube[0]
+			continue
+		}
+		res.Cubes[k] = cube
+	}
+	return res
+}
+
+// Place returns the volume of the given size with the voxels of vol moved by offset.
+// The voxels, which are moved out of the volume, are lost.
+// If the offset is a multiple of LeafSide, the leaf cubes are moved without touching the voxels,
+// except the partial cubes at the far sides of vol.
+func Place(vol *SparseVolume, offset g3.Node, size g3.Node) *SparseVolume {
+	res := NewSparseVolumeSize(size)
+	aligned := true
+	for _, v := range offset {
+		aligned = aligned && v%LeafSide == 0
+	}
+	for k, cube := range vol.Cubes {
+		if cube == nil && vol.Colors[k] == 0 {
+			continue
+		}
+		p := vol.k2point(k)
+		if aligned && vol.extent(k) == fullExtent {
+			dst := p.Add(offset)
+			if !res.inside(dst) {
+				continue
+			}
+			k2 := res.point2k(dst)
+			res.Colors[k2] = vol.Colors[k]
+			if cube != nil {
+				res.Cubes[k2] = append([]uint16(nil), cube...)
+			}
+			continue
+		}
+		for h := 0; h < 1<<(3*lh); h++ {
+			v := vol.Colors[k]
+			if cube != nil {
+				v = cube[h]
+			}
+			if src := p.Add(h2point(h)); v != 0 && vol.inside(src) {
+				res.Set16(src.Add(offset), v)
+			}
+		}
+	}
+	res.Compact()
+	return res
+}
+
+func checkSameSide(a, b *SparseVolume) {
+	if a.size != b.size {
+		panic(fmt.Sprintf("volume: the sizes of the volumes differ: %v and %v", a.size, b.size))
+	}
+}
@@ -0,0 +1,92 @@
+package volume
+
+import (
+	"testing"
+
+	"github.com/krasin/g3"
+)
+
+func boxVolume(n int, lo, hi [3]int, color uint16) *SparseVolume {
+	vol := NewSparseVolume(n)
+	for x := lo[0]; x < hi[0]; x++ {
+		for y := lo[1]; y < hi[1]; y++ {
+			for z := lo[2]; z < hi[2]; z++ {
+				vol.Set16(g3.Node{x, y, z}, color)
+			}
+		}
+	}
+	return vol
+}
+
+func TestCSG(t *testing.T) {
+	const n = 64
+	a := boxVolume(n, [3]int{0, 0, 0}, [3]int{40, 32, 50}, 1)
+	b := boxVolume(n, [3]int{20, 10, 0}, [3]int{64, 64, 32}, 2)
+	// Both volumes have the same uniform filled cube.
+	for _, vol := range []*SparseVolume{a, b} {
+		k := vol.Cube2k(g3.Node{0, 0, 0})
+		vol.Cubes[k], vol.Colors[k] = nil, vol.Colors[k]+3
+	}
+	tests := []struct {
+		name string
+		got  *SparseVolume
+		op   Op
+	}{
+		{"Union", Union(a, b), OpUnion},
+		{"Intersect", Intersect(a, b), OpIntersect},
+		{"Subtract", Subtract(a, b), OpSubtract},
+		{"Xor", Xor(a, b), OpXor},
+	}
+	for _, tt := range tests {
+		for x := 0; x < n; x++ {
+			for y := 0; y < n; y++ {
+				for z := 0; z < n; z++ {
+					node := g3.Node{x, y, z}
+					if want, got := tt.op(a.Get16(node), b.Get16(node)), tt.got.Get16(node); got != want {
+						t.Fatalf("%s at %v: want %d, got %d", tt.name, node, want, got)
+					}
+				}
+			}
+		}
+		if k := tt.got.Cube2k(g3.Node{0, 0, 0}); tt.got.Cubes[k] != nil {
+			t.Errorf("%s: the uniform cubes are combined voxel by voxel", tt.name)
+		}
+	}
+}
+
+func TestCombineOffset(t *testing.T) {
+	const n = 64
+	a := boxVolume(n, [3]int{0, 0, 0}, [3]int{40, 40, 40}, 1)
+	b := boxVolume(32, [3]int{0, 0, 0}, [3]int{32, 32, 32}, 2)
+	b.Cubes[0], b.Colors[0] = nil, 2
+	for _, offset := range []g3.Node{{32, 0, 32}, {30, -5, 7}} {
+		got := Combine(a, b, offset, OpSubtract)
+		for x := 0; x < n; x++ {
+			for y := 0; y < n; y++ {
+				for z := 0; z < n; z++ {
+					node := g3.Node{x, y, z}
+					want := OpSubtract(a.Get16(node), b.Get16(g3.Node{x - offset[0], y - offset[1], z - offset[2]}))
+					if v := got.Get16(node); v != want {
+						t.Fatalf("Combine with offset %v at %v: want %d, got %d", offset, node, want, v)
+					}
+				}
+			}
+		}
+	}
+	if placed := Place(b, g3.Node{32, 0, 32}, g3.Node{n, n, n}); placed.Cubes[placed.Cube2k(g3.Node{1, 0, 1})] != nil {
+		t.Errorf("Place: the aligned uniform cube is moved voxel by voxel")
+	}
+
+	// The partial cubes don't bring the voxels beyond the side of the box into the larger volume.
+	box := NewSparseVolumeSize(g3.Node{40, 40, 40})
+	for k := range box.Colors {
+		box.Colors[k] = 1
+	}
+	placed := Place(box, g3.Node{32, 0, 0}, g3.Node{128, 64, 64})
+	if got := placed.Volume(); got != 40*40*40 {
+		t.Errorf("Place of the box: want %d voxels, got %d", 40*40*40, got)
+	}
+	if !placed.Get(g3.Node{71, 39, 39}) || placed.Get(g3.Node{72, 0, 0}) {
+		t.Errorf("Place of the box: the voxels are misplaced")
+	}
+}
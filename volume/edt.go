@@ -0,0 +1,203 @@
+package volume
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/krasin/g3"
+)
+
+// EDT returns the exact Euclidean distance transform of vol up to max: every filled voxel gets
+// the distance from its center to the center of the nearest empty voxel, rounded up, so the voxels
+// next to the empty ones get 1, and the voxels farther than max get max+1. The empty voxels get 0.
+// Since the distance is rounded up, EDT(vol, max).Get16(node) <= d is the same as the true distance <= d.
+//
+// The transform is computed with the separable passes of P. Felzenszwalb, D. Huttenlocher,
+// "Distance Transforms of Sampled Functions", in linear time. The voxels just outside of vol are
+// looked up with vol.Get, so SparseVolume treats them as empty. max must be below math.MaxUint16,
+// so max+1 fits into the result. The result has the same size as vol.
+func EDT(vol Space, max int) *SparseVolume {
+	if max < 0 || max >= math.MaxUint16 {
+		panic(fmt.Sprintf("volume: EDT max %d is out of [0, %d)", max, math.MaxUint16))
+	}
+	size := vol.Size()
+	// The squared distances are capped at inf, which is still exact for the distances up to max.
+	// They are kept in sq until the last pass takes the square roots.
+	inf := (max + 1) * (max + 1)
+	sq := newSquares(size)
+	res := NewSparseVolumeSize(size)
+
+	// The first pass finds the distances along z, the others add y and x.
+	n := size[2]
+	e := newEnvelope(n, inf)
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			empty := true
+			for z := -1; z <= n; z++ {
+				if vol.Get(g3.Node{x, y, z}) {
+					e.f[z+1] = inf
+					empty = empty && (z < 0 || z >= n)
+				} else {
+					e.f[z+1] = 0
+				}
+			}
+			if empty {
+				continue
+			}
+			e.run()
+			for z := 0; z < n; z++ {
+				sq.set(g3.Node{x, y, z}, e.d[z+1])
+			}
+		}
+	}
+	for axis := 1; axis >= 0; axis-- {
+		n := size[axis]
+		e := newEnvelope(n, inf)
+		// The line goes along the axis, the other coordinates are a along the other axis of x and y, and b along z.
+		other := 1 - axis
+		for a := 0; a < size[other]; a++ {
+			for b := 0; b < size[2]; b++ {
+				var node g3.Node
+				node[other], node[2] = a, b
+				empty := true
+				for i := -1; i <= n; i++ {
+					node[axis] = i
+					if i < 0 || i >= n {
+						// The distances outside of the volume are not known, so only the empty voxels
+						// and the filled voxels, which are too far, are distinguished.
+						e.f[i+1] = 0
+						if vol.Get(node) {
+							e.f[i+1] = inf
+						}
+						continue
+					}
+					e.f[i+1] = sq.get(node)
+					empty = empty && e.f[i+1] == 0
+				}
+				if empty {
+					continue
+				}
+				e.run()
+				for i := 0; i < n; i++ {
+					node[axis] = i
+					v := e.d[i+1]
+					if axis > 0 {
+						sq.set(node, v)
+						continue
+					}
+					if v > 0 {
+						v = int(math.Ceil(math.Sqrt(float64(v))))
+						if v > max {
+							v = max + 1
+						}
+					}
+					res.Set16(node, uint16(v))
+				}
+			}
+		}
+	}
+	res.Compact()
+	return res
+}
+
+// squares keeps the squared distances between the passes of EDT in the leaf cubes of the layout.
+// They need more than 16 bits for the large max, and the cubes without them are not allocated.
+type squares struct {
+	layout
+	cubes [][]uint32
+}
+
+func newSquares(size g3.Node) *squares {
+	l := newLayout(size)
+	return &squares{layout: l, cubes: make([][]uint32, l.count)}
+}
+
+func (s *squares) get(node g3.Node) int {
+	cube := s.cubes[s.point2k(node)]
+	if cube == nil {
+		return 0
+	}
+	return int(cube[point2h(node)])
+}
+
+func (s *squares) set(node g3.Node, v int) {
+	k := s.point2k(node)
+	if s.cubes[k] == nil {
+		if v == 0 {
+			return
+		}
+		s.cubes[k] = make([]uint32, LeafSide*LeafSide*LeafSide)
+	}
+	s.cubes[k][point2h(node)] = uint32(v)
+}
+
+// envelope computes the lower envelope of the parabolas (q-p)² + f(p), see Felzenszwalb and Huttenlocher.
+type envelope struct {
+	inf int
+	// f is the input and d is the output, d[q] = min((q-p)² + f[p], inf).
+	f, d []int
+	// v contains the vertices of the parabolas in the envelope, and z contains the boundaries between them.
+	v []int
+	z []float64
+}
+
+func newEnvelope(n, inf int) *envelope {
+	return &envelope{
+		inf: inf,
+		f:   make([]int, n+2),
+		d:   make([]int, n+2),
+		v:   make([]int, n+2),
+		z:   make([]float64, n+3),
+	}
+}
+
+func (e *envelope) run() {
+	f := e.f
+	// The parabolas of the infinite points are skipped. k is the index of the last parabola in v.
+	k := -1
+	for q := range f {
+		if f[q] >= e.inf {
+			continue
+		}
+		if k < 0 {
+			k = 0
+			e.v[0] = q
+			e.z[0] = math.Inf(-1)
+			e.z[1] = math.Inf(1)
+			continue
+		}
+		// Remove the parabolas, which the new one hides to the right of their left boundary.
+		// z[0] is -inf, so the first parabola is never removed.
+		var s float64
+		for {
+			p := e.v[k]
+			s = float64((f[q]+q*q)-(f[p]+p*p)) / float64(2*(q-p))
+			if s > e.z[k] {
+				break
+			}
+			k--
+		}
+		k++
+		e.v[k] = q
+		e.z[k] = s
+		e.z[k+1] = math.Inf(1)
+	}
+	if k < 0 {
+		for q := range e.d {
+			e.d[q] = e.inf
+		}
+		return
+	}
+	k = 0
+	for q := range e.d {
+		for e.z[k+1] < float64(q) {
+			k++
+		}
+		p := e.v[k]
+		d := (q-p)*(q-p) + f[p]
+		if d > e.inf {
+			d = e.inf
+		}
+		e.d[q] = d
+	}
+}
@@ -0,0 +1,102 @@
+package volume
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/krasin/g3"
+)
+
+func TestEDT(t *testing.T) {
+	testEDT(t, g3.Node{32, 32, 32})
+	testEDT(t, g3.Node{45, 20, 37})
+}
+
+func testEDT(t *testing.T, size g3.Node) {
+	const max = 6
+	// A few random balls, one of which is cut by the side of the volume.
+	vol := NewSparseVolumeSize(size)
+	r := rand.New(rand.NewSource(1))
+	balls := [][4]float64{{3, 16, 16, 10}}
+	for i := 0; i < 5; i++ {
+		balls = append(balls, [4]float64{r.Float64() * float64(size[0]), r.Float64() * float64(size[1]), r.Float64() * float64(size[2]), 3 + r.Float64()*8})
+	}
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				for _, b := range balls {
+					dx, dy, dz := float64(x)-b[0], float64(y)-b[1], float64(z)-b[2]
+					if dx*dx+dy*dy+dz*dz <= b[3]*b[3] {
+						vol.Set16(g3.Node{x, y, z}, 1)
+					}
+				}
+			}
+		}
+	}
+
+	got := EDT(vol, max)
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				node := g3.Node{x, y, z}
+				var want uint16
+				if vol.Get(node) {
+					best := math.Inf(1)
+					for dx := -max - 1; dx <= max+1; dx++ {
+						for dy := -max - 1; dy <= max+1; dy++ {
+							for dz := -max - 1; dz <= max+1; dz++ {
+								if !vol.Get(g3.Node{x + dx, y + dy, z + dz}) {
+									best = math.Min(best, math.Sqrt(float64(dx*dx+dy*dy+dz*dz)))
+								}
+							}
+						}
+					}
+					want = max + 1
+					if best <= max {
+						want = uint16(math.Ceil(best))
+					}
+				}
+				if v := got.Get16(node); v != want {
+					t.Fatalf("EDT of %v at %v: want %d, got %d", size, node, want, v)
+				}
+			}
+		}
+	}
+}
+
+// slab is filled between the planes x = 0 and x = n-1 and infinite along y and z.
+type slab struct {
+	n int
+}
+
+func (s slab) Get(node g3.Node) bool { return node[0] >= 0 && node[0] < s.n }
+func (s slab) N() int                { return s.n }
+func (s slab) Size() g3.Node         { return g3.Node{s.n, 1, 1} }
+
+func TestEDTMax(t *testing.T) {
+	// The squared distances above 255 do not fit into uint16, and the center of the slab
+	// is farther than max from the empty space, which is 400 voxels away.
+	for _, max := range []int{255, 300} {
+		got := EDT(slab{800}, max)
+		for x := 0; x < 800; x++ {
+			want := x + 1
+			if 800-x < want {
+				want = 800 - x
+			}
+			if want > max {
+				want = max + 1
+			}
+			if v := got.Get16(g3.Node{x, 0, 0}); int(v) != want {
+				t.Fatalf("EDT with max=%d at x=%d: want %d, got %d", max, x, want, v)
+			}
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("EDT with max=%d must panic", math.MaxUint16)
+		}
+	}()
+	EDT(slab{1}, math.MaxUint16)
+}
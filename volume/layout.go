@@ -0,0 +1,163 @@
+package volume
+
+import (
+	"fmt"
+
+	"github.com/krasin/g3"
+)
+
+// layout maps the voxels of a box of size[0] × size[1] × size[2] voxels to the leaf cubes and back.
+//
+// The leaf cube k contains the voxels of the cube coordinate K2cube(k), which are ordered along the Z-order curve:
+// the bits of the cube coordinates are interleaved starting from z, and the axes, which are out of bits,
+// are skipped. So, a cubic volume with a power of two side has the usual Morton order, and a box of any shape
+// takes less than 8 times the number of its cubes. The cubes beyond the box, which get an index anyway,
+// are always empty.
+//
+// The leaf cubes at the far sides of the box may be partial. Their voxels beyond the box are never read:
+// the uniform cubes are uniform within the box, and the voxels outside of the box are empty.
+type layout struct {
+	n    int
+	size g3.Node
+	// cubes is the number of leaf cubes along each axis.
+	cubes g3.Node
+	// bits[i] is the list of the bits of k, which store the cube coordinate along the axis i, from the lowest one.
+	bits [3][]uint
+	// spread[i][c] is the cube coordinate c along the axis i with the bits moved to their places in k.
+	spread [3][]int
+	count  int
+}
+
+func newLayout(size g3.Node) layout {
+	l := layout{size: size}
+	var width [3]uint
+	for i, s := range size {
+		if s <= 0 {
+			panic(fmt.Sprintf("volume: bad size %v", size))
+		}
+		if s > l.n {
+			l.n = s
+		}
+		l.cubes[i] = (s + LeafSide - 1) / LeafSide
+		width[i] = log2(int64(2*l.cubes[i] - 1))
+	}
+	var pos uint
+	for b := uint(0); pos < width[0]+width[1]+width[2]; b++ {
+		for i := 2; i >= 0; i-- {
+			if b < width[i] {
+				l.bits[i] = append(l.bits[i], pos)
+				pos++
+			}
+		}
+	}
+	l.count = 1 << pos
+	for i := range l.spread {
+		l.spread[i] = make([]int, l.cubes[i])
+		for c := range l.spread[i] {
+			for b, p := range l.bits[i] {
+				l.spread[i][c] |= (c >> uint(b) & 1) << p
+			}
+		}
+	}
+	return l
+}
+
+// N returns the largest side of the volume.
+func (l *layout) N() int {
+	return l.n
+}
+
+// Size returns the number of voxels along each axis.
+func (l *layout) Size() g3.Node {
+	return l.size
+}
+
+// CubeCount returns the number of leaf cubes along each axis.
+func (l *layout) CubeCount() g3.Node {
+	return l.cubes
+}
+
+// Cube2k returns the index of the leaf cube with the cube coordinates c. c must be within the volume.
+func (l *layout) Cube2k(c g3.Node) int {
+	return l.spread[0][c[0]] | l.spread[1][c[1]] | l.spread[2][c[2]]
+}
+
+// K2cube returns the cube coordinates of the leaf cube k. They may be beyond the volume.
+func (l *layout) K2cube(k int) (c g3.Node) {
+	for i := range c {
+		for b, p := range l.bits[i] {
+			c[i] |= (k >> p & 1) << uint(b)
+		}
+	}
+	return
+}
+
+// Kh2point returns the voxel h of the leaf cube k.
+func (l *layout) Kh2point(k, h int) g3.Node {
+	return l.key2point(kh2key(k, h))
+}
+
+// inside returns whether the voxel is within the volume.
+func (l *layout) inside(node g3.Node) bool {
+	for i, v := range node {
+		if v < 0 || v >= l.size[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CubeInside returns whether the leaf cube with the cube coordinates c is within the volume.
+// The cubes beyond it are always empty.
+func (l *layout) CubeInside(c g3.Node) bool {
+	for i, v := range c {
+		if v < 0 || v >= l.cubes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fullExtent is the extent of the leaf cubes, which are entirely within the volume.
+var fullExtent = g3.Node{LeafSide, LeafSide, LeafSide}
+
+// extent returns the number of voxels of the leaf cube k within the volume along each axis.
+// It is LeafSide for all axes, unless the cube is at the far side of the volume.
+func (l *layout) extent(k int) (e g3.Node) {
+	p := l.k2point(k)
+	for i := range e {
+		e[i] = l.size[i] - p[i]
+		if e[i] > LeafSide {
+			e[i] = LeafSide
+		}
+		if e[i] < 0 {
+			e[i] = 0
+		}
+	}
+	return
+}
+
+func (l *layout) point2k(p g3.Node) int {
+	return l.spread[0][p[0]>>lh] | l.spread[1][p[1]>>lh] | l.spread[2][p[2]>>lh]
+}
+
+func (l *layout) k2point(k int) (p g3.Node) {
+	p = l.K2cube(k)
+	p[0] = p[0] << lh
+	p[1] = p[1] << lh
+	p[2] = p[2] << lh
+	return
+}
+
+func (l *layout) point2key(p g3.Node) uint64 {
+	return uint64(l.point2k(p))<<(3*lh) + uint64(point2h(p))
+}
+
+func (l *layout) key2point(key uint64) (p g3.Node) {
+	ph := h2point(key2h(key))
+	pk := l.k2point(key2k(key))
+	p[0] = pk[0] | ph[0]
+	p[1] = pk[1] | ph[1]
+	p[2] = pk[2] | ph[2]
+	return
+}
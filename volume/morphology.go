@@ -0,0 +1,336 @@
+package volume
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/krasin/g3"
+)
+
+// Element is a structuring element: the offsets of the voxels, which it covers.
+// It must contain the origin.
+type Element []g3.Node
+
+// Ball returns the voxels within the Euclidean distance r from the origin.
+func Ball(r int) Element {
+	return newElement(r, func(d g3.Node) bool {
+		return d[0]*d[0]+d[1]*d[1]+d[2]*d[2] <= r*r
+	})
+}
+
+// Cube returns the cube of side 2r+1 centered at the origin.
+func Cube(r int) Element {
+	return newElement(r, func(d g3.Node) bool { return true })
+}
+
+// Neighbourhood returns the voxels, which are reached from the origin in r steps
+// to the 6, 18 or 26 adjacent voxels, depending on conn.
+func Neighbourhood(conn, r int) Element {
+	return newElement(r, func(d g3.Node) bool {
+		l1 := abs(d[0]) + abs(d[1]) + abs(d[2])
+		switch conn {
+		case 6:
+			return l1 <= r
+		case 18:
+			return l1 <= 2*r
+		case 26:
+			return true
+		}
+		panic("volume.Neighbourhood: conn must be 6, 18 or 26")
+	})
+}
+
+func newElement(r int, in func(d g3.Node) bool) (e Element) {
+	for x := -r; x <= r; x++ {
+		for y := -r; y <= r; y++ {
+			for z := -r; z <= r; z++ {
+				if d := (g3.Node{x, y, z}); in(d) {
+					e = append(e, d)
+				}
+			}
+		}
+	}
+	return
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Dilate returns vol dilated by e: the voxel x is filled, if x-d is filled in vol for any d in e. The filled voxels of the result have the value 1.
+// The uniform leaf cubes, which are far enough from the others, are handled in O(1).
+func Dilate(vol *SparseVolume, e Element) *SparseVolume {
+	return morph(vol, e, false)
+}
+
+// Erode returns vol eroded by e: the voxel x is filled, if x+d is filled in vol for all d in e. The voxels outside of vol are empty.
+// The filled voxels of the result have the value 1.
+func Erode(vol *SparseVolume, e Element) *SparseVolume {
+	return morph(vol, e, true)
+}
+
+// Open returns vol eroded and then dilated by e. It removes the parts of the solid,
+// which are thinner than the element.
+func Open(vol *SparseVolume, e Element) *SparseVolume {
+	return Dilate(Erode(vol, e), e)
+}
+
+// Close returns vol dilated and then eroded by e. It fills the gaps and the holes,
+// which are thinner than the element.
+func Close(vol *SparseVolume, e Element) *SparseVolume {
+	return Erode(Dilate(vol, e), e)
+}
+
+// run is a part of the element: the offsets (dx, dy, z) for z in [z0, z1].
+// The length z1-z0 is lens[l] of the morpher.
+type run struct {
+	dx, dy, z0, z1 int
+	l              int
+}
+
+// morpher dilates the filled voxels (or the empty ones to erode the solid) one leaf cube at a time.
+// The voxels of the cube and the voxels within r from it are loaded into the bit lines along z.
+// The element is split into runs along z, so a line of the result is an OR of the lines,
+// which are dilated by the lengths of the runs and shifted.
+type morpher struct {
+	vol   *SparseVolume
+	runs  []run
+	r     int
+	erode bool
+	// w is the side of the window around the cube.
+	w int
+	// lens are the distinct lengths of the runs.
+	lens []int
+	// dilated[i][j] contains the bits of the line i of the window dilated by lens[j].
+	dilated [][][]uint64
+}
+
+func morph(vol *SparseVolume, e Element, erode bool) *SparseVolume {
+	m := &morpher{vol: vol, erode: erode}
+	// The result is read at the offsets of the element reflected for the dilation, since the voxel x
+	// of the dilation is filled if any of x-d is filled. Eroding is the same as dilating
+	// the empty voxels by the reflected element, so the element is used as is.
+	zs := make(map[[2]int][]bool)
+	for _, d := range e {
+		if !erode {
+			d = g3.Node{-d[0], -d[1], -d[2]}
+		}
+		for _, v := range d {
+			if abs(v) > m.r {
+				m.r = abs(v)
+			}
+		}
+	}
+	for _, d := range e {
+		if !erode {
+			d = g3.Node{-d[0], -d[1], -d[2]}
+		}
+		key := [2]int{d[0], d[1]}
+		if zs[key] == nil {
+			zs[key] = make([]bool, 2*m.r+1)
+		}
+		zs[key][d[2]+m.r] = true
+	}
+	lenIndex := make(map[int]int)
+	for key, z := range zs {
+		for i := 0; i < len(z); i++ {
+			if !z[i] {
+				continue
+			}
+			j := i
+			for j+1 < len(z) && z[j+1] {
+				j++
+			}
+			l, ok := lenIndex[j-i]
+			if !ok {
+				l = len(m.lens)
+				lenIndex[j-i] = l
+				m.lens = append(m.lens, j-i)
+			}
+			m.runs = append(m.runs, run{key[0], key[1], i - m.r, j - m.r, l})
+			i = j
+		}
+	}
+	m.w = LeafSide + 2*m.r
+
+	res := NewSparseVolumeSize(vol.size)
+	workers := runtime.NumCPU()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			cur := *m
+			for k := w; k < len(vol.Cubes); k += workers {
+				if !vol.CubeInside(vol.K2cube(k)) {
+					continue
+				}
+				res.Cubes[k], res.Colors[k] = cur.cube(k)
+			}
+		}(w)
+	}
+	wg.Wait()
+	return res
+}
+
+// filled returns whether the voxel is in the set, which is dilated.
+func (m *morpher) filled(node g3.Node) bool {
+	return m.vol.Get(node) != m.erode
+}
+
+// uniform returns whether all voxels of the leaf cube c are in the set, which is dilated, or all are not.
+// The partial cubes are never uniform, since they contain the voxels outside of the volume.
+func (m *morpher) uniform(c g3.Node) (ok, filled bool) {
+	if !m.vol.CubeInside(c) {
+		return true, m.erode
+	}
+	k := m.vol.Cube2k(c)
+	if m.vol.Cubes[k] != nil || m.vol.extent(k) != fullExtent {
+		return false, false
+	}
+	return true, (m.vol.Colors[k] != 0) != m.erode
+}
+
+// cube returns the leaf cube k of the result.
+func (m *morpher) cube(k int) ([]uint16, uint16) {
+	c := m.vol.K2cube(k)
+	// The cube is empty if there are no dilated voxels around it, and full, if the cube itself is.
+	if ok, filled := m.uniform(c); ok && filled {
+		return nil, m.color(true)
+	}
+	reach := (m.r + LeafSide - 1) / LeafSide
+	empty := true
+	for dx := -reach; dx <= reach && empty; dx++ {
+		for dy := -reach; dy <= reach && empty; dy++ {
+			for dz := -reach; dz <= reach && empty; dz++ {
+				ok, filled := m.uniform(g3.Node{c[0] + dx, c[1] + dy, c[2] + dz})
+				empty = ok && !filled
+			}
+		}
+	}
+	if empty {
+		return nil, m.color(false)
+	}
+
+	base := m.vol.k2point(k)
+	m.load(base)
+	var rows [LeafSide * LeafSide]uint32
+	all, none := true, true
+	for x := 0; x < LeafSide; x++ {
+		for y := 0; y < LeafSide; y++ {
+			var row uint32
+			for _, rn := range m.runs {
+				line := (x+rn.dx+m.r)*m.w + y + rn.dy + m.r
+				row |= extract32(m.dilated[line][rn.l], rn.z0+m.r)
+			}
+			if m.erode {
+				row = ^row
+			}
+			rows[x*LeafSide+y] = row
+			all = all && row == 1<<LeafSide-1
+			none = none && row == 0
+		}
+	}
+	if all {
+		return nil, 1
+	}
+	if none {
+		return nil, 0
+	}
+	cube := make([]uint16, 1<<(3*lh))
+	for i, row := range rows {
+		for z := 0; z < LeafSide; z++ {
+			if row&(1<<uint(z)) != 0 {
+				cube[i<<lh+z] = 1
+			}
+		}
+	}
+	return cube, 0
+}
+
+// color returns the color of the uniform cube of the result, which is dilated or not.
+func (m *morpher) color(dilated bool) uint16 {
+	if dilated != m.erode {
+		return 1
+	}
+	return 0
+}
+
+// load fills the dilated lines for the window around the cube at base.
+// The bit i of the line (x, y) is the voxel (base[0]-r+x, base[1]-r+y, base[2]-r+i).
+func (m *morpher) load(base g3.Node) {
+	words := (m.w + 63) / 64
+	if m.dilated == nil {
+		m.dilated = make([][][]uint64, m.w*m.w)
+		for i := range m.dilated {
+			m.dilated[i] = make([][]uint64, len(m.lens))
+			for j := range m.dilated[i] {
+				m.dilated[i][j] = make([]uint64, words)
+			}
+		}
+	}
+	maxLen := 0
+	for _, l := range m.lens {
+		if l > maxLen {
+			maxLen = l
+		}
+	}
+	line := make([]uint64, words)
+	cur := make([]uint64, words)
+	shifted := make([]uint64, words)
+	for x := 0; x < m.w; x++ {
+		for y := 0; y < m.w; y++ {
+			for i := range line {
+				line[i] = 0
+			}
+			for i := 0; i < m.w; i++ {
+				if m.filled(g3.Node{base[0] - m.r + x, base[1] - m.r + y, base[2] - m.r + i}) {
+					line[i/64] |= 1 << uint(i%64)
+				}
+			}
+			// The bit i of the line dilated by l is the OR of the bits i..i+l.
+			copy(cur, line)
+			for l := 0; l <= maxLen; l++ {
+				if l > 0 {
+					shiftRight(shifted, line, l)
+					for i := range cur {
+						cur[i] |= shifted[i]
+					}
+				}
+				for j, want := range m.lens {
+					if want == l {
+						copy(m.dilated[x*m.w+y][j], cur)
+					}
+				}
+			}
+		}
+	}
+}
+
+// shiftRight sets dst to src shifted right by s bits.
+func shiftRight(dst, src []uint64, s int) {
+	w, o := s/64, uint(s%64)
+	for i := range dst {
+		var v uint64
+		if i+w < len(src) {
+			v = src[i+w] >> o
+			if o > 0 && i+w+1 < len(src) {
+				v |= src[i+w+1] << (64 - o)
+			}
+		}
+		dst[i] = v
+	}
+}
+
+// extract32 returns 32 bits of the bit set starting from pos.
+func extract32(bits []uint64, pos int) uint32 {
+	w, o := pos/64, uint(pos%64)
+	v := bits[w] >> o
+	if o > 32 && w+1 < len(bits) {
+		v |= bits[w+1] << (64 - o)
+	}
+	return uint32(v)
+}
@@ -0,0 +1,135 @@
+package volume
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/krasin/g3"
+)
+
+func TestElements(t *testing.T) {
+	tests := []struct {
+		name string
+		e    Element
+		want int
+	}{
+		{"Ball(1)", Ball(1), 7},
+		{"Ball(2)", Ball(2), 33},
+		{"Cube(1)", Cube(1), 27},
+		{"Neighbourhood(6, 1)", Neighbourhood(6, 1), 7},
+		{"Neighbourhood(18, 1)", Neighbourhood(18, 1), 19},
+		{"Neighbourhood(26, 1)", Neighbourhood(26, 1), 27},
+		{"Neighbourhood(6, 2)", Neighbourhood(6, 2), 25},
+		{"Neighbourhood(18, 2)", Neighbourhood(18, 2), 93},
+	}
+	for _, tt := range tests {
+		if len(tt.e) != tt.want {
+			t.Errorf("%s: want %d voxels, got %d", tt.name, tt.want, len(tt.e))
+		}
+	}
+}
+
+// morphTestVolume returns a volume with a uniform filled cube, a few random boxes and random noise.
+// The size must be at least 64 along x and z and 32 along y.
+func morphTestVolume(size g3.Node) *SparseVolume {
+	vol := NewSparseVolumeSize(size)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 6; i++ {
+		var lo, hi [3]int
+		for j := range lo {
+			lo[j] = r.Intn(size[j])
+			hi[j] = lo[j] + 1 + r.Intn(20)
+		}
+		for x := lo[0]; x < hi[0]; x++ {
+			for y := lo[1]; y < hi[1]; y++ {
+				for z := lo[2]; z < hi[2]; z++ {
+					vol.Set16(g3.Node{x, y, z}, 3)
+				}
+			}
+		}
+	}
+	for i := 0; i < 300; i++ {
+		vol.Set16(g3.Node{r.Intn(size[0]), r.Intn(size[1]), r.Intn(size[2])}, 2)
+	}
+	k := vol.Cube2k(g3.Node{1, 0, 1})
+	vol.Cubes[k] = nil
+	vol.Colors[k] = 5
+	return vol
+}
+
+func TestMorphology(t *testing.T) {
+	testMorphology(t, morphTestVolume(g3.Node{64, 64, 64}))
+	box := morphTestVolume(g3.Node{70, 40, 75})
+	// A uniform filled cube, which is cut by the far side of the box.
+	k := box.Cube2k(g3.Node{0, 1, 0})
+	box.Cubes[k], box.Colors[k] = nil, 4
+	testMorphology(t, box)
+}
+
+func testMorphology(t *testing.T, vol *SparseVolume) {
+	size := vol.Size()
+	for _, e := range []Element{Ball(2), Cube(1), Neighbourhood(6, 3), append(Element{{0, 0, 0}}, g3.Node{1, 0, 2}, g3.Node{0, -3, 1})} {
+		dilated, eroded := Dilate(vol, e), Erode(vol, e)
+		if dilated.Size() != size || eroded.Size() != size {
+			t.Fatalf("the size of the result: want %v, got %v and %v", size, dilated.Size(), eroded.Size())
+		}
+		for x := 0; x < size[0]; x++ {
+			for y := 0; y < size[1]; y++ {
+				for z := 0; z < size[2]; z++ {
+					node := g3.Node{x, y, z}
+					any, all := false, true
+					for _, d := range e {
+						any = any || vol.Get(g3.Node{x - d[0], y - d[1], z - d[2]})
+						all = all && vol.Get(g3.Node{x + d[0], y + d[1], z + d[2]})
+					}
+					if got := dilated.Get16(node); got != b2u(any) {
+						t.Fatalf("Dilate %v by %v at %v: want %d, got %d", size, e, node, b2u(any), got)
+					}
+					if got := eroded.Get16(node); got != b2u(all) {
+						t.Fatalf("Erode %v by %v at %v: want %d, got %d", size, e, node, b2u(all), got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func b2u(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestMorphologyUniform(t *testing.T) {
+	// The filled cube in the middle stays uniform, and the far empty cubes stay empty.
+	vol := NewSparseVolume(128)
+	k := vol.Cube2k(g3.Node{1, 1, 1})
+	vol.Colors[k] = 1
+	dilated := Dilate(vol, Ball(3))
+	if dilated.Cubes[k] != nil || dilated.Colors[k] != 1 {
+		t.Errorf("Dilate: the filled cube is not uniform")
+	}
+	if got, want := dilated.Volume(), int64(38*38*38); got <= int64(32*32*32) || got > want {
+		t.Errorf("Dilate: the volume is %d, want between %d and %d", got, 32*32*32, want)
+	}
+	allocated := 0
+	for _, cube := range dilated.Cubes {
+		if cube != nil {
+			allocated++
+		}
+	}
+	if allocated != 26 {
+		t.Errorf("Dilate: want 26 allocated cubes around the filled one, got %d", allocated)
+	}
+	eroded := Erode(vol, Cube(1))
+	if got, want := eroded.Volume(), int64(30*30*30); got != want {
+		t.Errorf("Erode: want volume %d, got %d", want, got)
+	}
+	if closed := Close(vol, Ball(2)); closed.Volume() != vol.Volume() {
+		t.Errorf("Close: want volume %d, got %d", vol.Volume(), closed.Volume())
+	}
+	if opened := Open(vol, Cube(2)); opened.Volume() != vol.Volume() {
+		t.Errorf("Open: want volume %d, got %d", vol.Volume(), opened.Volume())
+	}
+}
@@ -0,0 +1,435 @@
+package volume
+
+import (
+	"fmt"
+
+	"github.com/krasin/g3"
+)
+
+// Octree is a box of voxels stored as a sparse octree. Every node is either uniform, or has 8 children,
+// or, at the bottom level, contains the voxels of a leaf cube of side LeafSide, like SparseVolume.Cubes.
+// The nodes are split on the first write of a different color into them, and Compact collapses
+// the uniform ones back, so the memory is proportional to the surface of the solid, not to its volume.
+// Unlike SparseVolume, nothing is allocated up front, so it suits the very large volumes.
+//
+// Like SparseVolume, the nodes at the far sides of the box may be partial.
+// Their voxels beyond the box are never read.
+type Octree struct {
+	n    int
+	size g3.Node
+	// levels is the level of the root. The nodes of the level l have the side LeafSide << l.
+	levels int
+	root   octNode
+}
+
+type octNode struct {
+	// kids are the children of an interior node, ordered by the bits x<<2 | y<<1 | z of their positions.
+	kids *[8]octNode
+	// voxels are the colors of the voxels of a non-uniform leaf cube, the voxel h2point(h) has the color voxels[h].
+	voxels []uint16
+	// color is the color of all voxels of a uniform node.
+	color uint16
+}
+
+// nodeBytes is the memory taken by a node on a 64-bit platform: the pointer, the slice header and the padded color.
+const nodeBytes = 40
+
+// NewOctree creates an empty voxel cube with side n.
+func NewOctree(n int) *Octree {
+	return NewOctreeSize(g3.Node{n, n, n})
+}
+
+// NewOctreeSize creates an empty box of voxels with the given number of voxels along each axis.
+func NewOctreeSize(size g3.Node) *Octree {
+	o := &Octree{size: size}
+	for _, s := range size {
+		if s <= 0 {
+			panic(fmt.Sprintf("volume: bad size %v", size))
+		}
+		if s > o.n {
+			o.n = s
+		}
+	}
+	for LeafSide<<uint(o.levels) < o.n {
+		o.levels++
+	}
+	return o
+}
+
+// N returns the largest side of the volume.
+func (o *Octree) N() int {
+	return o.n
+}
+
+// Size returns the number of voxels along each axis.
+func (o *Octree) Size() g3.Node {
+	return o.size
+}
+
+func (o *Octree) inside(node g3.Node) bool {
+	for i, v := range node {
+		if v < 0 || v >= o.size[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// extent returns the number of voxels of the node at p of the given level within the volume along each axis.
+func (o *Octree) extent(p g3.Node, level int) (e g3.Node) {
+	for i := range e {
+		e[i] = o.size[i] - p[i]
+		if side := LeafSide << uint(level); e[i] > side {
+			e[i] = side
+		}
+	}
+	return
+}
+
+// child returns the index of the child of the node of the given level, which contains the voxel.
+func child(node g3.Node, level int) int {
+	s := uint(lh + level - 1)
+	return (node[0]>>s&1)<<2 | (node[1]>>s&1)<<1 | node[2]>>s&1
+}
+
+// childOrigin returns the origin of the child i of the node at p of the given level.
+func childOrigin(p g3.Node, level, i int) g3.Node {
+	half := LeafSide << uint(level-1)
+	return g3.Node{p[0] + (i>>2&1)*half, p[1] + (i>>1&1)*half, p[2] + (i&1)*half}
+}
+
+// Get returns true, if the voxel is filled (color != 0).
+func (o *Octree) Get(node g3.Node) bool {
+	return o.Get16(node) != 0
+}
+
+// Get16 returns the color of the voxel (empty voxel has color == 0).
+func (o *Octree) Get16(node g3.Node) uint16 {
+	if !o.inside(node) {
+		return 0
+	}
+	n := &o.root
+	for level := o.levels; n.kids != nil; level-- {
+		n = &n.kids[child(node, level)]
+	}
+	if n.voxels != nil {
+		return n.voxels[point2h(node)]
+	}
+	return n.color
+}
+
+// Set16 sets the color of the voxel. The uniform nodes on the way are split.
+func (o *Octree) Set16(node g3.Node, val uint16) {
+	if !o.inside(node) {
+		return
+	}
+	n := &o.root
+	for level := o.levels; ; level-- {
+		if n.kids == nil && n.voxels == nil {
+			if n.color == val {
+				return
+			}
+			n.split(level)
+		}
+		if n.voxels != nil {
+			n.voxels[point2h(node)] = val
+			return
+		}
+		n = &n.kids[child(node, level)]
+	}
+}
+
+// split turns the uniform node into the children or the voxels of the same color.
+func (n *octNode) split(level int) {
+	if level == 0 {
+		n.voxels = make([]uint16, 1<<(3*lh))
+		for i := range n.voxels {
+			n.voxels[i] = n.color
+		}
+		return
+	}
+	n.kids = new([8]octNode)
+	for i := range n.kids {
+		n.kids[i].color = n.color
+	}
+}
+
+// walk invokes f on every node without children, which is within the volume, with its origin and level.
+func (o *Octree) walk(f func(n *octNode, p g3.Node, level int)) {
+	o.walkNode(&o.root, g3.Node{}, o.levels, f)
+}
+
+func (o *Octree) walkNode(n *octNode, p g3.Node, level int, f func(n *octNode, p g3.Node, level int)) {
+	if !o.inside(p) {
+		return
+	}
+	if n.kids == nil {
+		f(n, p, level)
+		return
+	}
+	for i := range n.kids {
+		o.walkNode(&n.kids[i], childOrigin(p, level, i), level-1, f)
+	}
+}
+
+// SetAllFilled sets the specified color to all voxels with color >= threshold.
+func (o *Octree) SetAllFilled(threshold, val uint16) {
+	o.walk(func(n *octNode, p g3.Node, level int) {
+		if n.voxels == nil {
+			if n.color >= threshold {
+				n.color = val
+			}
+			return
+		}
+		for h, cur := range n.voxels {
+			if cur >= threshold {
+				n.voxels[h] = val
+			}
+		}
+	})
+	o.Compact()
+}
+
+// Volume returns the number of the filled voxels.
+func (o *Octree) Volume() (res int64) {
+	o.walk(func(n *octNode, p g3.Node, level int) {
+		e := o.extent(p, level)
+		if n.voxels == nil {
+			if n.color != 0 {
+				res += int64(e[0]) * int64(e[1]) * int64(e[2])
+			}
+			return
+		}
+		for x := 0; x < e[0]; x++ {
+			for y := 0; y < e[1]; y++ {
+				for z := 0; z < e[2]; z++ {
+					if n.voxels[point2h(g3.Node{x, y, z})] != 0 {
+						res++
+					}
+				}
+			}
+		}
+	})
+	return
+}
+
+// MapBoundary invokes a provided function on every border voxel.
+// Only the faces of the uniform filled nodes are looked at, since their
+// inner voxels are surrounded by filled voxels.
+func (o *Octree) MapBoundary(f func(node g3.Node)) {
+	o.walk(func(n *octNode, p g3.Node, level int) {
+		if n.voxels == nil && n.color == 0 {
+			return
+		}
+		e := o.extent(p, level)
+		for x := 0; x < e[0]; x++ {
+			for y := 0; y < e[1]; y++ {
+				for z := 0; z < e[2]; z++ {
+					inner := x > 0 && x < e[0]-1 && y > 0 && y < e[1]-1 && z > 0 && z < e[2]-1
+					if n.voxels == nil && inner {
+						z = e[2] - 2
+						continue
+					}
+					p2 := g3.Node{p[0] + x, p[1] + y, p[2] + z}
+					if IsBoundary(o, p2) {
+						f(p2)
+					}
+				}
+			}
+		}
+	})
+}
+
+// Compact collapses the leaf cubes and the interior nodes, which have the same color in all voxels,
+// and returns the number of bytes freed.
+func (o *Octree) Compact() int64 {
+	return o.compactNode(&o.root, g3.Node{}, o.levels)
+}
+
+func (o *Octree) compactNode(n *octNode, p g3.Node, level int) (freed int64) {
+	if n.voxels != nil {
+		// Only the voxels within the volume are compared.
+		e := o.extent(p, level)
+		first := n.voxels[0]
+		for x := 0; x < e[0]; x++ {
+			for y := 0; y < e[1]; y++ {
+				for z := 0; z < e[2]; z++ {
+					if n.voxels[point2h(g3.Node{x, y, z})] != first {
+						return 0
+					}
+				}
+			}
+		}
+		n.color = first
+		n.voxels = nil
+		return 2 << (3 * lh)
+	}
+	if n.kids == nil {
+		return 0
+	}
+	uniform := true
+	var color uint16
+	for i := range n.kids {
+		kid, q := &n.kids[i], childOrigin(p, level, i)
+		if !o.inside(q) {
+			// The children beyond the volume don't matter.
+			continue
+		}
+		freed += o.compactNode(kid, q, level-1)
+		if kid.kids != nil || kid.voxels != nil || i > 0 && kid.color != color {
+			uniform = false
+		}
+		color = kid.color
+	}
+	if uniform {
+		n.color = color
+		n.kids = nil
+		freed += 8 * nodeBytes
+	}
+	return
+}
+
+// octRegion is a part of the empty space: a uniform empty node, or an empty voxel of a leaf cube,
+// then the level is -1.
+type octRegion struct {
+	n     *octNode
+	p     g3.Node
+	level int
+}
+
+// FillInterior sets the color of the empty voxels, which are not connected to the outside of the volume
+// through the 6-adjacent empty voxels, i.e. fills the cavities of the solid. The uniform empty nodes
+// are flooded as a whole, so the time is proportional to the surface of the solid, not to its volume.
+func (o *Octree) FillInterior(color uint16) {
+	visitedNodes := make(map[*octNode]bool)
+	visitedVoxels := make(map[*octNode][]uint64)
+	var stack []octRegion
+	add := func(r octRegion) {
+		if r.level >= 0 {
+			if visitedNodes[r.n] {
+				return
+			}
+			visitedNodes[r.n] = true
+		} else {
+			bits := visitedVoxels[r.n]
+			if bits == nil {
+				bits = make([]uint64, cubeWords)
+				visitedVoxels[r.n] = bits
+			}
+			h := point2h(r.p)
+			if bits[h>>6]&(1<<uint(h&63)) != 0 {
+				return
+			}
+			bits[h>>6] |= 1 << uint(h&63)
+		}
+		stack = append(stack, r)
+	}
+
+	// The empty space at the sides of the volume is reached from the outside.
+	for i := range o.size {
+		lo, hi := g3.Node{}, o.size
+		hi[i] = 1
+		o.visitEmpty(lo, hi, add)
+		lo[i], hi[i] = o.size[i]-1, o.size[i]
+		o.visitEmpty(lo, hi, add)
+	}
+	for len(stack) > 0 {
+		r := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if r.level < 0 {
+			// The neighbours within the same leaf cube are looked up directly.
+			for _, d := range g3.AdjNodes6 {
+				q := g3.Node{r.p[0] + d[0], r.p[1] + d[1], r.p[2] + d[2]}
+				if q[0]>>lh == r.p[0]>>lh && q[1]>>lh == r.p[1]>>lh && q[2]>>lh == r.p[2]>>lh {
+					if o.inside(q) && r.n.voxels[point2h(q)] == 0 {
+						add(octRegion{r.n, q, -1})
+					}
+					continue
+				}
+				o.visitEmpty(q, g3.Node{q[0] + 1, q[1] + 1, q[2] + 1}, add)
+			}
+			continue
+		}
+		// The slabs next to the faces of the node.
+		e := o.extent(r.p, r.level)
+		for i := range e {
+			lo, hi := r.p, g3.Node{r.p[0] + e[0], r.p[1] + e[1], r.p[2] + e[2]}
+			lo[i], hi[i] = r.p[i]-1, r.p[i]
+			o.visitEmpty(lo, hi, add)
+			lo[i], hi[i] = r.p[i]+e[i], r.p[i]+e[i]+1
+			o.visitEmpty(lo, hi, add)
+		}
+	}
+
+	o.walk(func(n *octNode, p g3.Node, level int) {
+		if n.voxels == nil {
+			if n.color == 0 && !visitedNodes[n] {
+				n.color = color
+			}
+			return
+		}
+		bits := visitedVoxels[n]
+		for h, v := range n.voxels {
+			if v == 0 && (bits == nil || bits[h>>6]&(1<<uint(h&63)) == 0) {
+				n.voxels[h] = color
+			}
+		}
+	})
+	o.Compact()
+}
+
+// visitEmpty invokes f on every empty region, which intersects the box [lo, hi) within the volume.
+func (o *Octree) visitEmpty(lo, hi g3.Node, f func(r octRegion)) {
+	for i := range lo {
+		if lo[i] < 0 {
+			lo[i] = 0
+		}
+		if hi[i] > o.size[i] {
+			hi[i] = o.size[i]
+		}
+		if lo[i] >= hi[i] {
+			return
+		}
+	}
+	o.visitNode(&o.root, g3.Node{}, o.levels, lo, hi, f)
+}
+
+func (o *Octree) visitNode(n *octNode, p g3.Node, level int, lo, hi g3.Node, f func(r octRegion)) {
+	side := LeafSide << uint(level)
+	for i := range p {
+		if p[i] >= hi[i] || p[i]+side <= lo[i] {
+			return
+		}
+	}
+	if n.kids != nil {
+		for i := range n.kids {
+			o.visitNode(&n.kids[i], childOrigin(p, level, i), level-1, lo, hi, f)
+		}
+		return
+	}
+	if n.voxels == nil {
+		if n.color == 0 {
+			f(octRegion{n, p, level})
+		}
+		return
+	}
+	var a, b g3.Node
+	for i := range p {
+		a[i], b[i] = lo[i], hi[i]
+		if a[i] < p[i] {
+			a[i] = p[i]
+		}
+		if b[i] > p[i]+side {
+			b[i] = p[i] + side
+		}
+	}
+	for x := a[0]; x < b[0]; x++ {
+		for y := a[1]; y < b[1]; y++ {
+			for z := a[2]; z < b[2]; z++ {
+				if q := (g3.Node{x, y, z}); n.voxels[point2h(q)] == 0 {
+					f(octRegion{n, q, -1})
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package volume
+
+import (
+	"testing"
+
+	"github.com/krasin/g3"
+)
+
+func TestOctree(t *testing.T) {
+	ref := morphTestVolume(g3.Node{70, 40, 75})
+	size := ref.Size()
+	o := NewOctreeSize(size)
+	for x := 0; x < size[0]; x++ {
+		for y := 0; y < size[1]; y++ {
+			for z := 0; z < size[2]; z++ {
+				node := g3.Node{x, y, z}
+				o.Set16(node, ref.Get16(node))
+			}
+		}
+	}
+	// The far cubes are uniform and compacted, the root is split.
+	if freed := o.Compact(); freed <= 0 {
+		t.Errorf("Compact: want some bytes freed, got %d", freed)
+	}
+	if o.root.kids == nil {
+		t.Fatalf("Compact: the root is collapsed")
+	}
+	for x := -1; x <= size[0]; x++ {
+		for y := -1; y <= size[1]; y++ {
+			for z := -1; z <= size[2]; z++ {
+				node := g3.Node{x, y, z}
+				if want, got := ref.Get16(node), o.Get16(node); got != want {
+					t.Fatalf("Get16(%v): want %d, got %d", node, want, got)
+				}
+			}
+		}
+	}
+	if want, got := ref.Volume(), o.Volume(); got != want {
+		t.Errorf("Volume: want %d, got %d", want, got)
+	}
+	want := make(map[g3.Node]bool)
+	ref.MapBoundary(func(node g3.Node) { want[node] = true })
+	got := make(map[g3.Node]bool)
+	o.MapBoundary(func(node g3.Node) {
+		if got[node] {
+			t.Errorf("MapBoundary: %v is reported twice", node)
+		}
+		got[node] = true
+	})
+	if len(got) != len(want) {
+		t.Errorf("MapBoundary: want %d voxels, got %d", len(want), len(got))
+	}
+	for node := range want {
+		if !got[node] {
+			t.Fatalf("MapBoundary: %v is missing", node)
+		}
+	}
+
+	// Clearing everything collapses the whole tree.
+	o.SetAllFilled(1, 0)
+	if o.root.kids != nil || o.root.voxels != nil || o.root.color != 0 || o.Volume() != 0 {
+		t.Errorf("SetAllFilled: the empty tree is not collapsed")
+	}
+}
+
+func TestOctreeLarge(t *testing.T) {
+	// Nothing is allocated up front, and a box costs only the nodes along its faces.
+	o := NewOctree(8192)
+	for x := 1000; x < 1100; x++ {
+		for y := 1000; y < 1100; y++ {
+			o.Set16(g3.Node{x, y, 2000}, 1)
+		}
+	}
+	if got := o.Volume(); got != 100*100 {
+		t.Errorf("Volume: want %d, got %d", 100*100, got)
+	}
+	o.Set16(g3.Node{8191, 8191, 8191}, 2)
+	if o.Get16(g3.Node{8191, 8191, 8191}) != 2 || o.Get16(g3.Node{8191, 8191, 8190}) != 0 {
+		t.Errorf("Get16: the far corner is wrong")
+	}
+}
+
+func TestFillInterior(t *testing.T) {
+	// Two hollow boxes with the walls of 2 voxels: a closed one and the one with a hole.
+	// The closed box crosses the borders of the large nodes.
+	o := NewOctreeSize(g3.Node{200, 100, 90})
+	walls := func(lo, hi g3.Node) {
+		for x := lo[0]; x < hi[0]; x++ {
+			for y := lo[1]; y < hi[1]; y++ {
+				for z := lo[2]; z < hi[2]; z++ {
+					if x < lo[0]+2 || x >= hi[0]-2 || y < lo[1]+2 || y >= hi[1]-2 || z < lo[2]+2 || z >= hi[2]-2 {
+						o.Set16(g3.Node{x, y, z}, 1)
+					}
+				}
+			}
+		}
+	}
+	walls(g3.Node{10, 5, 5}, g3.Node{150, 80, 85})
+	walls(g3.Node{160, 10, 10}, g3.Node{195, 40, 40})
+	for x := 170; x < 180; x++ {
+		o.Set16(g3.Node{x, 20, 39}, 0)
+		o.Set16(g3.Node{x, 20, 38}, 0)
+	}
+	o.FillInterior(5)
+
+	tests := []struct {
+		node g3.Node
+		want uint16
+	}{
+		{g3.Node{0, 0, 0}, 0},
+		{g3.Node{10, 5, 5}, 1},
+		{g3.Node{12, 7, 7}, 5},
+		{g3.Node{80, 40, 40}, 5},
+		{g3.Node{147, 77, 82}, 5},
+		{g3.Node{148, 77, 82}, 1},
+		{g3.Node{155, 50, 50}, 0},
+		{g3.Node{175, 25, 25}, 0},
+		{g3.Node{199, 99, 89}, 0},
+	}
+	for _, tt := range tests {
+		if got := o.Get16(tt.node); got != tt.want {
+			t.Errorf("Get16(%v): want %d, got %d", tt.node, tt.want, got)
+		}
+	}
+	want := int64(140 * 75 * 80)
+	want += 35*30*30 - 31*26*26 - 2*10
+	if got := o.Volume(); got != want {
+		t.Errorf("Volume: want %d, got %d", want, got)
+	}
+}
@@ -8,11 +8,20 @@ const (
 
 	masklh  = (1 << lh) - 1
 	mask3lh = (1 << (3 * lh)) - 1
+
+	// LeafSide is the side of the leaf cube of SparseVolume.
+	LeafSide = 1 << lh
 )
 
-// SparseVolume represents a voxel cube.
+// SparseVolume represents a box of voxels.
+// The leaf cubes are stored in the order of the layout: Cubes[k] contains the colors of the voxels
+// of the leaf cube k, or it's nil, if all voxels of the cube have the color Colors[k].
 type SparseVolume struct {
-	n      int
+	layout
+	// LK is the log2 of the number of leaf cubes along the side of the smallest
+	// power of two cube, which contains the volume.
+	//
+	// Deprecated: the volume is not a cube anymore. Use Size and CubeCount.
 	LK     int
 	Cubes  [][]uint16
 	Colors []uint16
@@ -20,12 +29,23 @@ type SparseVolume struct {
 
 // NewSparseVolume create a voxel cube with side n.
 func NewSparseVolume(n int) (v *SparseVolume) {
-	lk := int(log2(int64(n)) - lh)
+	return NewSparseVolumeSize(g3.Node{n, n, n})
+}
+
+// NewSparseVolumeSize creates an empty box of voxels with the given number of voxels along each axis.
+func NewSparseVolumeSize(size g3.Node) *SparseVolume {
+	l := newLayout(size)
+	var lk int
+	for _, b := range l.bits {
+		if len(b) > lk {
+			lk = len(b)
+		}
+	}
 	return &SparseVolume{
-		n:      n,
+		layout: l,
 		LK:     lk,
-		Cubes:  make([][]uint16, 1<<uint(3*lk)),
-		Colors: make([]uint16, 1<<uint(3*lk)),
+		Cubes:  make([][]uint16, l.count),
+		Colors: make([]uint16, l.count),
 	}
 }
 
@@ -36,30 +56,22 @@ func (v *SparseVolume) Get(node g3.Node) bool {
 
 // Get16 returns the color of the voxel (empty voxel has color == 0).
 func (vol *SparseVolume) Get16(node g3.Node) uint16 {
-	for _, v := range node {
-		if v < 0 || v >= vol.n {
-			return 0
-		}
+	if !vol.inside(node) {
+		return 0
 	}
-	k := point2k(node)
+	k := vol.point2k(node)
 	if vol.Cubes[k] == nil {
 		return vol.Colors[k]
 	}
 	return vol.Cubes[k][point2h(node)]
 }
 
-func (vol *SparseVolume) N() int {
-	return vol.n
-}
-
 // Set sets the color of the voxel.
 func (vol *SparseVolume) Set16(node g3.Node, val uint16) {
-	for _, v := range node {
-		if v < 0 || v >= vol.n {
-			return
-		}
+	if !vol.inside(node) {
+		return
 	}
-	k := point2k(node)
+	k := vol.point2k(node)
 	if vol.Cubes[k] == nil {
 		if vol.Colors[k] == val {
 			return
@@ -78,7 +90,7 @@ func (vol *SparseVolume) Set16(node g3.Node, val uint16) {
 func (v *SparseVolume) SetAllFilled(threshold, val uint16) {
 	for k, cube := range v.Cubes {
 		if cube == nil {
-			if v.Colors[k] >= threshold {
+			if v.Colors[k] >= threshold && v.CubeInside(v.K2cube(k)) {
 				v.Colors[k] = val
 			}
 			continue
@@ -88,37 +100,78 @@ func (v *SparseVolume) SetAllFilled(threshold, val uint16) {
 				cube[h] = val
 			}
 		}
+		v.compactCube(k)
+	}
+}
+
+// Compact folds the leaf cubes, which have the same color in all voxels, back into Colors,
+// and returns the number of bytes freed. Set16 expands a uniform cube on the first write
+// and never folds it back, so Compact is worth calling after many writes.
+// SetAllFilled compacts the cubes itself.
+func (v *SparseVolume) Compact() (freed int64) {
+	for k := range v.Cubes {
+		freed += v.compactCube(k)
 	}
+	return
+}
+
+func (v *SparseVolume) compactCube(k int) int64 {
+	cube := v.Cubes[k]
+	if cube == nil {
+		return 0
+	}
+	// Only the voxels within the volume are compared.
+	e := v.extent(k)
+	if e == fullExtent {
+		for _, cur := range cube {
+			if cur != cube[0] {
+				return 0
+			}
+		}
+	} else {
+		for x := 0; x < e[0]; x++ {
+			for y := 0; y < e[1]; y++ {
+				for z := 0; z < e[2]; z++ {
+					if cube[point2h(g3.Node{x, y, z})] != cube[0] {
+						return 0
+					}
+				}
+			}
+		}
+	}
+	v.Colors[k] = cube[0]
+	v.Cubes[k] = nil
+	return 2 * int64(len(cube))
 }
 
 // MapBoundary invokes a provided function on every border voxel.
 func (v *SparseVolume) MapBoundary(f func(node g3.Node)) {
+	size := v.Size()
 	for k, cube := range v.Cubes {
+		p := v.k2point(k)
+		e := v.extent(k)
 		if cube == nil {
 			// Skip empty cubes
 			if v.Colors[k] == 0 {
 				continue
 			}
-			p := k2point(k)
-			side := 1 << uint(v.LK)
-			for x := 0; x < side; x++ {
+			for x := 0; x < e[0]; x++ {
 				var p2 g3.Node
 				p2[0] = p[0] + x
 				cnt1 := 0
-				if x == 0 || x == side-1 {
+				if x == 0 || x == e[0]-1 {
 					cnt1++
 				}
-				for y := 0; y < side; y++ {
+				for y := 0; y < e[1]; y++ {
 					p2[1] = p[1] + y
 					cnt2 := cnt1
-					if y == 0 || y == side-1 {
+					if y == 0 || y == e[1]-1 {
 						cnt2++
 					}
-					for z := 0; z < side; z++ {
-						if cnt2 == 2 && (z == 0 || z == side-10) {
-							if z == 0 {
-								z = side - 2
-							}
+					for z := 0; z < e[2]; z++ {
+						if cnt2 == 0 && z > 0 && z < e[2]-1 {
+							// The inner voxels of a uniform cube are surrounded by filled voxels.
+							z = e[2] - 2
 							continue
 						}
 						p2[2] = p[2] + z
@@ -128,19 +181,24 @@ func (v *SparseVolume) MapBoundary(f func(node g3.Node)) {
 					}
 				}
 			}
+			continue
 		}
 		for h, cur := range cube {
 			if cur == 0 {
 				continue
 			}
-			p := key2point(kh2key(k, h))
+			hp := h2point(h)
+			if hp[0] >= e[0] || hp[1] >= e[1] || hp[2] >= e[2] {
+				// The voxel of a partial cube, which is outside of the volume.
+				continue
+			}
+			p2 := p.Add(hp)
 
-			if p[0] == 0 || p[1] == 0 || p[2] == 0 ||
-				int(p[0]) == v.n-1 || int(p[1]) == v.n-1 || int(p[2]) == v.n-1 {
-				f(p)
+			if p2[0] == 0 || p2[1] == 0 || p2[2] == 0 ||
+				p2[0] == size[0]-1 || p2[1] == size[1]-1 || p2[2] == size[2]-1 {
+				f(p2)
 				continue
 			}
-			hp := h2point(h)
 
 			was := false
 			for i := 0; i < 3; i++ {
@@ -148,16 +206,16 @@ func (v *SparseVolume) MapBoundary(f func(node g3.Node)) {
 					hp2 := hp
 					hp2[i]--
 					if cube[point2h(hp2)] == 0 {
-						f(p)
+						f(p2)
 						was = true
 						break
 					}
 				}
-				if hp[i] < (1<<lh)-1 {
+				if hp[i] < e[i]-1 {
 					hp2 := hp
 					hp2[i]++
 					if cube[point2h(hp2)] == 0 {
-						f(p)
+						f(p2)
 						was = true
 						break
 					}
@@ -169,20 +227,18 @@ func (v *SparseVolume) MapBoundary(f func(node g3.Node)) {
 			// Slow path for cube edges
 			for i := 0; i < 3; i++ {
 				if hp[i] == 0 {
-					p2 := p
-					p2[i]--
-					if v.Get16(p2) == 0 {
-						f(p)
-						was = true
+					p3 := p2
+					p3[i]--
+					if v.Get16(p3) == 0 {
+						f(p2)
 						break
 					}
 				}
-				if hp[i] == (1<<lh)-1 {
-					p2 := p
-					p2[i]++
-					if v.Get16(p2) == 0 {
-						f(p)
-						was = true
+				if hp[i] == e[i]-1 {
+					p3 := p2
+					p3[i]++
+					if v.Get16(p3) == 0 {
+						f(p2)
 						break
 					}
 				}
@@ -193,56 +249,66 @@ func (v *SparseVolume) MapBoundary(f func(node g3.Node)) {
 
 func (v *SparseVolume) Volume() (res int64) {
 	for k, cube := range v.Cubes {
+		e := v.extent(k)
 		if cube == nil {
 			// Skip empty cubes
 			if v.Colors[k] == 0 {
 				continue
 			}
-			side := int64(1 << uint(v.LK))
-			res += side * side * side
+			res += int64(e[0] * e[1] * e[2])
+			continue
+		}
+		if e == fullExtent {
+			for _, val := range cube {
+				if val != 0 {
+					res++
+				}
+			}
 			continue
 		}
-		for _, val := range cube {
-			if val != 0 {
-				res++
+		for x := 0; x < e[0]; x++ {
+			for y := 0; y < e[1]; y++ {
+				for z := 0; z < e[2]; z++ {
+					if cube[point2h(g3.Node{x, y, z})] != 0 {
+						res++
+					}
+				}
 			}
 		}
 	}
 	return res
 }
 
-func log2(n int64) (res uint) {
-	for n > 1 {
-		n >>= 1
-		res++
-	}
-	return
-}
-
-func point2key(p g3.Node) uint64 {
-	return uint64(point2k(p))<<(3*lh) + uint64(point2h(p))
-}
+// mortonLayout is the layout of the largest cubic volume, which the package functions below support:
+// 256 leaf cubes along each axis. The layout of any cubic volume with a power of two side is its prefix.
+var mortonLayout = newLayout(g3.Node{256 << lh, 256 << lh, 256 << lh})
 
-func point2k(p g3.Node) int {
-	return (spread3(byte(p[0]>>lh)) << 2) + (spread3(byte(p[1]>>lh)) << 1) + spread3(byte(p[2]>>lh))
+// K2cube returns the cube coordinates of the leaf cube k of a cubic volume with a power of two side.
+//
+// Deprecated: use the K2cube method of the volume, which supports any size.
+func K2cube(k int) g3.Node {
+	return mortonLayout.K2cube(k)
 }
 
-func K2cube(k int) (p g3.Node) {
-	p[0] = int(join3((k >> 2) & 0x249249))
-	p[1] = int(join3((k >> 1) & 0x249249))
-	p[2] = int(join3(k & 0x249249))
-	return
+// Cube2k returns the index of the leaf cube with the cube coordinates p of a cubic volume with a power of two side.
+//
+// Deprecated: use the Cube2k method of the volume, which supports any size.
+func Cube2k(p g3.Node) int {
+	return mortonLayout.Cube2k(p)
 }
 
-func Cube2k(p g3.Node) int {
-	return (spread3(byte(p[0])) << 2) + (spread3(byte(p[1])) << 1) + spread3(byte(p[2]))
+// Kh2point returns the voxel h of the leaf cube k of a cubic volume with a power of two side.
+//
+// Deprecated: use the Kh2point method of the volume, which supports any size.
+func Kh2point(k, h int) g3.Node {
+	return mortonLayout.Kh2point(k, h)
 }
 
-func k2point(k int) (p g3.Node) {
-	p = K2cube(k)
-	p[0] = p[0] << lh
-	p[1] = p[1] << lh
-	p[2] = p[2] << lh
+func log2(n int64) (res uint) {
+	for n > 1 {
+		n >>= 1
+		res++
+	}
 	return
 }
 
@@ -257,21 +323,6 @@ func h2point(h int) (p g3.Node) {
 	return
 }
 
-func spread3(b byte) (x int) {
-	x = int(b)
-	x = ((x & 0xF0) << 8) | (x & 0x0F)
-	x = ((x & 0xC00C) << 4) | (x & 0x3003)
-	x = ((x & 0x82082) << 2) | (x & 0x41041)
-	return
-}
-
-func join3(x int) (b byte) {
-	x = ((x & 0x208208) >> 2) | (x & 0xDF7DF7)
-	x = ((x & 0xC00C0) >> 4) | (x & 0x3FF3F)
-	x = ((x & 0xF000) >> 8) | (x & 0x0FFF)
-	return byte(x)
-}
-
 func key2h(key uint64) int {
 	return int(key & mask3lh)
 }
@@ -280,19 +331,6 @@ func key2k(key uint64) int {
 	return int(key >> (3 * lh))
 }
 
-func key2point(key uint64) (p g3.Node) {
-	ph := h2point(key2h(key))
-	pk := k2point(key2k(key))
-	p[0] = pk[0] | ph[0]
-	p[1] = pk[1] | ph[1]
-	p[2] = pk[2] | ph[2]
-	return
-}
-
 func kh2key(k, h int) uint64 {
 	return (uint64(k) << (3 * lh)) | uint64(h)
 }
-
-func Kh2point(k, h int) g3.Node {
-	return key2point(kh2key(k, h))
-}
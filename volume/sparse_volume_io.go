@@ -0,0 +1,229 @@
+package volume
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/krasin/g3"
+)
+
+// The on-disk format of SparseVolume (all numbers are little endian):
+//
+//	magic   [4]byte  "SVOL"
+//	version uint32
+//	size    [3]uint32
+//	leaf    uint32   side of the leaf cube (1 << lh)
+//	colors  [len(Colors)]uint16
+//	crc     uint32   CRC-32 (IEEE) of everything above
+//	count   uint32   number of non-nil cubes
+//
+// followed by count leaf records:
+//
+//	k       uint32
+//	voxels  [1 << (3*lh)]uint16
+//	crc     uint32   CRC-32 (IEEE) of k and voxels
+//
+// The version 1 has a cube of side n instead of size, and LK, the log2 of the number of leaf cubes
+// along each axis, after it. It's still read.
+const (
+	sparseVolumeMagic   = "SVOL"
+	sparseVolumeVersion = 2
+)
+
+// The limits on the volumes, which are read, so the corrupted header does not exhaust the memory.
+const (
+	maxSide  = 1 << 20
+	maxCubes = 1 << 30
+)
+
+// ErrChecksum is returned by ReadFrom if the stored data is corrupted.
+var ErrChecksum = errors.New("volume: checksum mismatch")
+
+// WriteTo writes the volume to w in a versioned binary format.
+// It implements io.WriterTo.
+func (vol *SparseVolume) WriteTo(w io.Writer) (n int64, err error) {
+	cw := &countWriter{w: w}
+	h := crc32.NewIEEE()
+	out := io.MultiWriter(cw, h)
+
+	if _, err = io.WriteString(out, sparseVolumeMagic); err != nil {
+		return cw.n, err
+	}
+	hdr := []uint32{sparseVolumeVersion, uint32(vol.size[0]), uint32(vol.size[1]), uint32(vol.size[2]), 1 << lh}
+	if err = binary.Write(out, binary.LittleEndian, hdr); err != nil {
+		return cw.n, err
+	}
+	if err = binary.Write(out, binary.LittleEndian, vol.Colors); err != nil {
+		return cw.n, err
+	}
+	if err = binary.Write(cw, binary.LittleEndian, h.Sum32()); err != nil {
+		return cw.n, err
+	}
+
+	var count uint32
+	for _, cube := range vol.Cubes {
+		if cube != nil {
+			count++
+		}
+	}
+	if err = binary.Write(cw, binary.LittleEndian, count); err != nil {
+		return cw.n, err
+	}
+	for k, cube := range vol.Cubes {
+		if cube == nil {
+			continue
+		}
+		h.Reset()
+		if err = binary.Write(out, binary.LittleEndian, uint32(k)); err != nil {
+			return cw.n, err
+		}
+		if err = binary.Write(out, binary.LittleEndian, cube); err != nil {
+			return cw.n, err
+		}
+		if err = binary.Write(cw, binary.LittleEndian, h.Sum32()); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// ReadFrom replaces the content of the volume with the data previously
+// written by WriteTo. It implements io.ReaderFrom.
+func (vol *SparseVolume) ReadFrom(r io.Reader) (n int64, err error) {
+	cr := &countReader{r: r}
+	h := crc32.NewIEEE()
+	in := io.TeeReader(cr, h)
+
+	var magic [4]byte
+	if _, err = io.ReadFull(in, magic[:]); err != nil {
+		return cr.n, err
+	}
+	if string(magic[:]) != sparseVolumeMagic {
+		return cr.n, fmt.Errorf("volume: bad magic %q", magic[:])
+	}
+	var version uint32
+	if err = binary.Read(in, binary.LittleEndian, &version); err != nil {
+		return cr.n, err
+	}
+	var hdr [4]uint32
+	switch version {
+	case 1:
+		err = binary.Read(in, binary.LittleEndian, hdr[1:])
+	case sparseVolumeVersion:
+		err = binary.Read(in, binary.LittleEndian, hdr[:])
+	default:
+		return cr.n, fmt.Errorf("volume: unsupported format version %d", version)
+	}
+	if err != nil {
+		return cr.n, err
+	}
+	if hdr[3] != 1<<lh {
+		return cr.n, fmt.Errorf("volume: unsupported leaf size %d", hdr[3])
+	}
+	size := g3.Node{int(hdr[0]), int(hdr[1]), int(hdr[2])}
+	if version == 1 {
+		side, lk := int(hdr[1]), int(hdr[2])
+		if lk < 0 || lk > 8 || side > 1<<uint(lk+lh) {
+			return cr.n, fmt.Errorf("volume: bad dimensions: n=%d, LK=%d", side, lk)
+		}
+		size = g3.Node{side, side, side}
+	}
+	for _, v := range size {
+		if v <= 0 || v > maxSide {
+			return cr.n, fmt.Errorf("volume: bad size %v", size)
+		}
+	}
+	l := newLayout(size)
+	if l.count > maxCubes {
+		return cr.n, fmt.Errorf("volume: too large: %v", size)
+	}
+	if version == 1 && l.count != 1<<uint(3*hdr[2]) {
+		return cr.n, fmt.Errorf("volume: bad dimensions: n=%d, LK=%d", hdr[1], hdr[2])
+	}
+	colors := make([]uint16, l.count)
+	if err = binary.Read(in, binary.LittleEndian, colors); err != nil {
+		return cr.n, err
+	}
+	if err = checkSum(cr, h); err != nil {
+		return cr.n, err
+	}
+
+	var count uint32
+	if err = binary.Read(cr, binary.LittleEndian, &count); err != nil {
+		return cr.n, err
+	}
+	if int64(count) > int64(len(colors)) {
+		return cr.n, fmt.Errorf("volume: too many cubes: %d", count)
+	}
+	cubes := make([][]uint16, len(colors))
+	for i := uint32(0); i < count; i++ {
+		h.Reset()
+		var k uint32
+		if err = binary.Read(in, binary.LittleEndian, &k); err != nil {
+			return cr.n, err
+		}
+		if int64(k) >= int64(len(cubes)) || cubes[k] != nil {
+			return cr.n, fmt.Errorf("volume: bad cube index %d", k)
+		}
+		cube := make([]uint16, 1<<(3*lh))
+		if err = binary.Read(in, binary.LittleEndian, cube); err != nil {
+			return cr.n, err
+		}
+		if err = checkSum(cr, h); err != nil {
+			return cr.n, err
+		}
+		cubes[k] = cube
+	}
+
+	vol.layout = l
+	vol.Colors = colors
+	vol.Cubes = cubes
+	return cr.n, nil
+}
+
+// ReadSparseVolume reads a volume written by SparseVolume.WriteTo.
+func ReadSparseVolume(r io.Reader) (*SparseVolume, error) {
+	vol := new(SparseVolume)
+	if _, err := vol.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return vol, nil
+}
+
+func checkSum(r io.Reader, h hash.Hash32) error {
+	want := h.Sum32()
+	var got uint32
+	if err := binary.Read(r, binary.LittleEndian, &got); err != nil {
+		return err
+	}
+	if got != want {
+		return ErrChecksum
+	}
+	return nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.n += int64(n)
+	return
+}
@@ -0,0 +1,81 @@
+package volume
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/krasin/g3"
+)
+
+func TestSparseVolumeWriteRead(t *testing.T) {
+	vol := NewSparseVolumeSize(g3.Node{128, 70, 110})
+	vol.Colors[vol.Cube2k(g3.Node{1, 2, 3})] = 7
+	for x := 10; x < 50; x++ {
+		vol.Set16(g3.Node{x, x / 2, 100 - x}, uint16(x))
+	}
+
+	var buf bytes.Buffer
+	n, err := vol.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo: returned %d, but wrote %d bytes", n, buf.Len())
+	}
+	data := buf.Bytes()
+
+	got, err := ReadSparseVolume(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadSparseVolume: %v", err)
+	}
+	if got.Size() != vol.Size() {
+		t.Fatalf("ReadSparseVolume: want size %v, got %v", vol.Size(), got.Size())
+	}
+	for k := range vol.Cubes {
+		if got.Colors[k] != vol.Colors[k] {
+			t.Errorf("Colors[%d]: want %d, got %d", k, vol.Colors[k], got.Colors[k])
+		}
+		if (got.Cubes[k] == nil) != (vol.Cubes[k] == nil) {
+			t.Fatalf("Cubes[%d]: nil mismatch", k)
+		}
+		for h := range vol.Cubes[k] {
+			if got.Cubes[k][h] != vol.Cubes[k][h] {
+				t.Fatalf("Cubes[%d][%d]: want %d, got %d", k, h, vol.Cubes[k][h], got.Cubes[k][h])
+			}
+		}
+	}
+
+	// Flip a single voxel in the last cube and expect the checksum to fail.
+	data[len(data)-10] ^= 1
+	if _, err = ReadSparseVolume(bytes.NewReader(data)); err != ErrChecksum {
+		t.Errorf("ReadSparseVolume of corrupted data: want %v, got %v", ErrChecksum, err)
+	}
+}
+
+func TestSparseVolumeReadVersion1(t *testing.T) {
+	// A cube of side 64 with the uniform filled cube (1, 0, 0).
+	var buf bytes.Buffer
+	buf.WriteString(sparseVolumeMagic)
+	colors := make([]uint16, 8)
+	colors[4] = 3
+	binary.Write(&buf, binary.LittleEndian, []uint32{1, 64, 1, 1 << lh})
+	binary.Write(&buf, binary.LittleEndian, colors)
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+
+	vol, err := ReadSparseVolume(&buf)
+	if err != nil {
+		t.Fatalf("ReadSparseVolume: %v", err)
+	}
+	if want := (g3.Node{64, 64, 64}); vol.Size() != want {
+		t.Fatalf("ReadSparseVolume: want size %v, got %v", want, vol.Size())
+	}
+	if got := vol.Get16(g3.Node{40, 5, 5}); got != 3 {
+		t.Errorf("Get16(40, 5, 5): want 3, got %d", got)
+	}
+	if got := vol.Volume(); got != LeafSide*LeafSide*LeafSide {
+		t.Errorf("Volume: want %d, got %d", LeafSide*LeafSide*LeafSide, got)
+	}
+}
@@ -7,10 +7,11 @@ import (
 )
 
 type spread3test struct {
-	num  byte
+	num  int
 	want int
 }
 
+// spread3tests are the cube coordinates along z and their Morton codes.
 var spread3tests = []spread3test{
 	{0, 0},
 	{1, 1},
@@ -23,17 +24,68 @@ var spread3tests = []spread3test{
 
 func TestSpread3(t *testing.T) {
 	for testInd, test := range spread3tests {
-		got := spread3(test.num)
+		got := Cube2k(g3.Node{0, 0, test.num})
 		if got != test.want {
-			t.Errorf("test #%d: spread3(%d): want %d (0x%x), got %d (0x%x)", testInd, test.num, test.want, test.want, got, got)
+			t.Errorf("test #%d: Cube2k(0, 0, %d): want %d (0x%x), got %d (0x%x)", testInd, test.num, test.want, test.want, got, got)
 		}
-		gotNum := join3(test.want)
-		if gotNum != test.num {
-			t.Errorf("test #%d: join3(%d): want %d, got %d", testInd, test.want, test.num, gotNum)
+		gotCube := K2cube(test.want)
+		if gotCube != (g3.Node{0, 0, test.num}) {
+			t.Errorf("test #%d: K2cube(%d): want %v, got %v", testInd, test.want, g3.Node{0, 0, test.num}, gotCube)
 		}
 	}
 }
 
+func TestCubicLayout(t *testing.T) {
+	// The deprecated package functions match the layout of any cubic volume with a power of two side.
+	vol := NewSparseVolume(128)
+	if vol.LK != 2 {
+		t.Errorf("LK: want 2, got %d", vol.LK)
+	}
+	for k := range vol.Cubes {
+		if got, want := K2cube(k), vol.K2cube(k); got != want {
+			t.Errorf("K2cube(%d): want %v, got %v", k, want, got)
+		}
+		if got, want := Kh2point(k, 12345), vol.Kh2point(k, 12345); got != want {
+			t.Errorf("Kh2point(%d, 12345): want %v, got %v", k, want, got)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	for _, size := range []g3.Node{{1000, 64, 40}, {20, 300, 20}, {33, 33, 33}, {1, 1, 1}, {64, 64, 64}} {
+		l := newLayout(size)
+		var want int
+		seen := make(map[int]bool)
+		for x := 0; x < l.cubes[0]; x++ {
+			for y := 0; y < l.cubes[1]; y++ {
+				for z := 0; z < l.cubes[2]; z++ {
+					c := g3.Node{x, y, z}
+					k := l.Cube2k(c)
+					if k < 0 || k >= l.count || seen[k] {
+						t.Fatalf("size %v: Cube2k(%v) = %d is out of range or not unique", size, c, k)
+					}
+					seen[k] = true
+					if got := l.K2cube(k); got != c {
+						t.Errorf("size %v: K2cube(%d): want %v, got %v", size, k, c, got)
+					}
+					want++
+				}
+			}
+		}
+		// The cube count is rounded up to a power of two along each axis.
+		if l.count >= 8*want {
+			t.Errorf("size %v: %d cubes take %d indices", size, want, l.count)
+		}
+	}
+	l := newLayout(g3.Node{1000, 64, 40})
+	if want := (g3.Node{32, 2, 2}); l.CubeCount() != want || l.N() != 1000 {
+		t.Errorf("CubeCount: want %v, got %v, N: want 1000, got %d", want, l.CubeCount(), l.N())
+	}
+	if e := l.extent(l.Cube2k(g3.Node{31, 1, 1})); e != (g3.Node{8, 32, 8}) {
+		t.Errorf("extent of the far cube: want (8, 32, 8), got %v", e)
+	}
+}
+
 type point2hTest struct {
 	p               g3.Node
 	h               int
@@ -79,11 +131,11 @@ var point2kTests = []point2kTest{
 
 func TestPoint2k(t *testing.T) {
 	for testInd, test := range point2kTests {
-		gotK := point2k(test.p)
+		gotK := mortonLayout.point2k(test.p)
 		if gotK != test.k {
 			t.Errorf("test #%d: point2k(%v): want %d (0x%x), got %d (0x%x)", testInd, test.p, test.k, test.k, gotK, gotK)
 		}
-		gotP := k2point(test.k)
+		gotP := mortonLayout.k2point(test.k)
 		if gotP != test.p {
 			t.Errorf("test #%d: k2point(%d): want %v, got %v", testInd, test.k, test.p, gotP)
 		}
@@ -105,13 +157,161 @@ var point2keyTests = []point2keyTest{
 
 func TestPoint2Key(t *testing.T) {
 	for testInd, test := range point2keyTests {
-		got := point2key(test.p)
+		got := mortonLayout.point2key(test.p)
 		if got != test.key {
 			t.Errorf("test #%d: point2key(%v): want %d (0x%x), got %d (0x%x)", testInd, test.p, test.key, test.key, got, got)
 		}
-		gotP := key2point(test.key)
+		gotP := mortonLayout.key2point(test.key)
 		if gotP != test.p {
 			t.Errorf("test #%d: key2point(%d): want %v, got %v", testInd, test.key, test.p, gotP)
 		}
 	}
 }
+
+func TestMapBoundary(t *testing.T) {
+	for _, size := range []g3.Node{{64, 64, 64}, {100, 70, 90}} {
+		vol := NewSparseVolumeSize(size)
+		// A box, which covers a uniform cube and touches the partially filled ones.
+		for x := 16; x < 50; x++ {
+			for y := 30; y < size[1]; y++ {
+				for z := 0; z < 40; z++ {
+					vol.Set16(g3.Node{x, y, z}, 1)
+				}
+			}
+		}
+		// The uniform cubes at the side of the volume, inside of it, and the partial one at the far corner.
+		last := vol.CubeCount().Sub(g3.Node{1, 1, 1})
+		for _, c := range []g3.Node{{1, 1, 0}, {1, 1, 1}, last} {
+			vol.Cubes[vol.Cube2k(c)] = nil
+			vol.Colors[vol.Cube2k(c)] = 1
+		}
+
+		want := make(map[g3.Node]bool)
+		for x := 0; x < size[0]; x++ {
+			for y := 0; y < size[1]; y++ {
+				for z := 0; z < size[2]; z++ {
+					if node := (g3.Node{x, y, z}); IsBoundary(vol, node) {
+						want[node] = true
+					}
+				}
+			}
+		}
+		got := make(map[g3.Node]bool)
+		vol.MapBoundary(func(node g3.Node) {
+			if got[node] {
+				t.Errorf("size %v: MapBoundary: %v is reported twice", size, node)
+			}
+			got[node] = true
+		})
+		if len(got) != len(want) {
+			t.Errorf("size %v: MapBoundary: want %d voxels, got %d", size, len(want), len(got))
+		}
+		for node := range want {
+			if !got[node] {
+				t.Fatalf("size %v: MapBoundary: %v is missing", size, node)
+			}
+		}
+	}
+}
+
+func TestNormal(t *testing.T) {
+	vol := NewSparseVolume(64)
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			for z := 0; z < 20; z++ {
+				vol.Set16(g3.Node{x, y, z}, 1)
+			}
+		}
+	}
+	// The top face of the slab looks up, and its edge looks aside as well.
+	if got, want := Normal(vol, g3.Node{30, 30, 19}), (g3.Vector{0, 0, 1}); got != want {
+		t.Errorf("Normal at the face: want %v, got %v", want, got)
+	}
+	if got := Normal(vol, g3.Node{30, 63, 19}); got[0] != 0 || got[1] <= 0 || got[2] <= 0 {
+		t.Errorf("Normal at the edge: got %v, want it to look along +y and +z", got)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	vol := NewSparseVolume(64)
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 32; y++ {
+			for z := 0; z < 32; z++ {
+				vol.Set16(g3.Node{x, y, z}, 7)
+			}
+		}
+	}
+	// The cube (0, 0, 0) is uniform, but expanded, and the cube (1, 0, 0) is not uniform.
+	if vol.Cubes[0] == nil {
+		t.Fatal("Set16 must expand the cube")
+	}
+	want := int64(2 * LeafSide * LeafSide * LeafSide)
+	if freed := vol.Compact(); freed != want {
+		t.Errorf("Compact: want %d bytes freed, got %d", want, freed)
+	}
+	if vol.Cubes[0] != nil || vol.Colors[0] != 7 {
+		t.Errorf("Compact: the uniform cube is not folded")
+	}
+	if k := vol.Cube2k(g3.Node{1, 0, 0}); vol.Cubes[k] == nil {
+		t.Errorf("Compact: the non-uniform cube is folded")
+	}
+	if got := vol.Volume(); got != 40*32*32 {
+		t.Errorf("Volume: want %d, got %d", 40*32*32, got)
+	}
+	if freed := vol.Compact(); freed != 0 {
+		t.Errorf("the second Compact: want 0 bytes freed, got %d", freed)
+	}
+
+	// SetAllFilled compacts the cubes, which become uniform.
+	vol.SetAllFilled(1, 0)
+	for k, cube := range vol.Cubes {
+		if cube != nil || vol.Colors[k] != 0 {
+			t.Fatalf("SetAllFilled: cube %d is not empty and folded", k)
+		}
+	}
+}
+
+func TestSparseVolumeBox(t *testing.T) {
+	size := g3.Node{70, 40, 33}
+	vol := NewSparseVolumeSize(size)
+	if vol.N() != 70 || vol.CubeCount() != (g3.Node{3, 2, 2}) {
+		t.Fatalf("N: want 70, got %d, CubeCount: want (3, 2, 2), got %v", vol.N(), vol.CubeCount())
+	}
+	// Everything is filled, including the voxels beyond the box, which must be ignored.
+	for x := 0; x < 96; x++ {
+		for y := 0; y < 64; y++ {
+			for z := 0; z < 64; z++ {
+				vol.Set16(g3.Node{x, y, z}, 2)
+			}
+		}
+	}
+	if vol.Get(g3.Node{70, 0, 0}) || vol.Get(g3.Node{0, 40, 0}) || vol.Get(g3.Node{0, 0, 33}) || !vol.Get(g3.Node{69, 39, 32}) {
+		t.Errorf("Get: the bounds don't match the size")
+	}
+	want := int64(size[0] * size[1] * size[2])
+	if got := vol.Volume(); got != want {
+		t.Errorf("Volume: want %d, got %d", want, got)
+	}
+	var boundary int64
+	vol.MapBoundary(func(node g3.Node) { boundary++ })
+	wantBoundary := want - int64((size[0]-2)*(size[1]-2)*(size[2]-2))
+	if boundary != wantBoundary {
+		t.Errorf("MapBoundary: want %d voxels, got %d", wantBoundary, boundary)
+	}
+
+	// All cubes are uniform within the box.
+	vol.Compact()
+	for k, cube := range vol.Cubes {
+		if cube != nil {
+			t.Fatalf("Compact: the cube %v is not folded", vol.K2cube(k))
+		}
+	}
+	if got := vol.Volume(); got != want {
+		t.Errorf("Volume after Compact: want %d, got %d", want, got)
+	}
+	boundary = 0
+	vol.MapBoundary(func(node g3.Node) { boundary++ })
+	if boundary != wantBoundary {
+		t.Errorf("MapBoundary after Compact: want %d voxels, got %d", wantBoundary, boundary)
+	}
+}